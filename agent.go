@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The agent holds the keys of unlocked vaults in memory, so that commands do
+// not prompt for the password (and derive the key) every time they operate on
+// a vault.
+// It listens on a Unix socket which is only accessible by the user, and
+// serves a single JSON encoded request per connection.
+
+// agentTimeout bounds the time taken by a request to the agent.
+const agentTimeout = 5 * time.Second
+
+// Operations of the agent.
+const (
+	// agentGet returns the key of a vault
+	agentGet = "get"
+	// agentAdd stores the key of a vault
+	agentAdd = "add"
+	// agentLock discards the key of a vault, or of all vaults
+	agentLock = "lock"
+)
+
+var errNoAgent = fmt.Errorf("no agent is running")
+
+type agentRequest struct {
+	Op string `json:"op"`
+	// absolute path of the vault directory
+	Vault string `json:"vault,omitempty"`
+	Key   []byte `json:"key,omitempty"`
+}
+
+type agentResponse struct {
+	Error string `json:"error,omitempty"`
+	Key   []byte `json:"key,omitempty"`
+}
+
+// agentSocket returns the path of the agent's socket: $GRINGOTTS_AGENT_SOCK,
+// or a socket in the user's runtime (or temporary) directory.
+func agentSocket() string {
+	if p := os.Getenv("GRINGOTTS_AGENT_SOCK"); p != "" {
+		return p
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "gringotts", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gringotts-%d", os.Getuid()), "agent.sock")
+}
+
+// agentVaultID returns the identifier of the vault in the directory dir, under
+// which the agent stores its key.
+func agentVaultID(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// agent stores the keys of the unlocked vaults.
+type agent struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+	// idle discards the keys once the agent has not been used for
+	// idleTimeout (if it is not 0)
+	idle        *time.Timer
+	idleTimeout time.Duration
+}
+
+// runAgent runs the agent on the socket at path until it is interrupted.
+func runAgent(path string, idleTimeout time.Duration) error {
+	if err := prepareSocketDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if conn, err := net.DialTimeout("unix", path, agentTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("an agent is already running on '%s'", path)
+	}
+	// the socket of an agent which did not exit cleanly
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("error listening on '%s': %s", path, err.Error())
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return fmt.Errorf("error securing '%s': %s", path, err.Error())
+	}
+	hardenProcess()
+
+	a := &agent{keys: make(map[string][]byte), idleTimeout: idleTimeout}
+	if idleTimeout > 0 {
+		a.idle = time.AfterFunc(idleTimeout, a.lockAll)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "Agent listening on %s\n", path)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// the listener was closed by a signal
+			a.lockAll()
+			return nil
+		}
+		go a.serve(conn)
+	}
+}
+
+// prepareSocketDir creates the directory of the agent's socket, only
+// accessible by the user, if it does not exist, and checks that it is owned by
+// the user.
+func prepareSocketDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating '%s': %s", dir, err.Error())
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("error reading '%s': %s", dir, err.Error())
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", dir)
+	}
+	if owner := fileOwner(info); owner != nil && owner.Uid != os.Getuid() {
+		return fmt.Errorf("'%s' is not owned by the user", dir)
+	}
+	return nil
+}
+
+// serve handles a request on conn.
+func (a *agent) serve(conn net.Conn) {
+	defer conn.Close()
+	if err := checkPeer(conn); err != nil {
+		fmt.Fprintf(os.Stderr, "gringotts agent: rejected connection: %s\n", err.Error())
+		return
+	}
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	resp := a.handle(req)
+	wipe(req.Key)
+	json.NewEncoder(conn).Encode(resp)
+	wipe(resp.Key)
+}
+
+// handle performs a request.
+func (a *agent) handle(req agentRequest) agentResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.idle != nil {
+		a.idle.Reset(a.idleTimeout)
+	}
+	switch req.Op {
+	case agentGet:
+		key, ok := a.keys[req.Vault]
+		if !ok {
+			return agentResponse{Error: "vault is locked"}
+		}
+		return agentResponse{Key: append([]byte(nil), key...)}
+	case agentAdd:
+		if req.Vault == "" || len(req.Key) == 0 {
+			return agentResponse{Error: "missing vault or key"}
+		}
+		a.remove(req.Vault)
+		key := make([]byte, len(req.Key))
+		copy(key, req.Key)
+		if err := lockMemory(key); err != nil {
+			fmt.Fprintf(os.Stderr, "gringotts agent: could not lock key in memory: %s\n", err.Error())
+		}
+		a.keys[req.Vault] = key
+		return agentResponse{}
+	case agentLock:
+		if req.Vault != "" {
+			a.remove(req.Vault)
+		} else {
+			for vault := range a.keys {
+				a.remove(vault)
+			}
+		}
+		return agentResponse{}
+	}
+	return agentResponse{Error: fmt.Sprintf("unknown operation '%s'", req.Op)}
+}
+
+// remove discards the key of the vault, overwriting it.
+// The caller must hold a.mu.
+func (a *agent) remove(vault string) {
+	if key, ok := a.keys[vault]; ok {
+		wipe(key)
+		unlockMemory(key)
+		delete(a.keys, vault)
+	}
+}
+
+// lockAll discards the keys of all vaults.
+func (a *agent) lockAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for vault := range a.keys {
+		a.remove(vault)
+	}
+}
+
+// wipe overwrites b with zeros.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// callAgent sends a request to the agent and returns its response, or
+// errNoAgent if no agent is running.
+func callAgent(req agentRequest) (agentResponse, error) {
+	var resp agentResponse
+	conn, err := net.DialTimeout("unix", agentSocket(), agentTimeout)
+	if err != nil {
+		return resp, errNoAgent
+	}
+	defer conn.Close()
+	if err := checkPeer(conn); err != nil {
+		return resp, fmt.Errorf("untrusted agent: %s", err.Error())
+	}
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, fmt.Errorf("agent error: %s", err.Error())
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("agent error: %s", err.Error())
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("agent error: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+// agentOpen opens the vault in the directory dir with the key held by the
+// agent, returning nil if there is no agent or it does not hold a (valid) key
+// for the vault.
+func agentOpen(dir string) *AESVault {
+	resp, err := callAgent(agentRequest{Op: agentGet, Vault: agentVaultID(dir)})
+	if err != nil {
+		return nil
+	}
+	params, err := readParams(dir)
+	if err != nil {
+		return nil
+	}
+	// the key is no longer valid if the vault's password was changed
+	v, err := openWithKey(dir, params, resp.Key)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// agentStore stores the key of the vault in the agent, if one is running.
+func agentStore(v *AESVault) error {
+	_, err := callAgent(agentRequest{Op: agentAdd, Vault: agentVaultID(v.dirName), Key: v.key})
+	if err == errNoAgent {
+		return nil
+	}
+	return err
+}
+
+// agentEntries returns the names of the files in the vault with the given
+// name, if it is unlocked in the agent.
+func agentEntries(name string) ([]string, error) {
+	_, dir, err := resolveVault(name)
+	if err != nil {
+		return nil, err
+	}
+	v := agentOpen(dir)
+	if v == nil {
+		return nil, fmt.Errorf("vault is locked")
+	}
+	var names []string
+	for _, entry := range v.latestEntries() {
+		names = append(names, entry.Filename)
+	}
+	return names, nil
+}
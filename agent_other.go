@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "net"
+
+// Peer credentials and locked memory are only supported on Linux; elsewhere,
+// access to the agent is restricted by the permissions of its socket's
+// directory.
+
+func checkPeer(conn net.Conn) error { return nil }
+
+func lockMemory(b []byte) error { return nil }
+
+func unlockMemory(b []byte) {}
+
+func hardenProcess() {}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// The browser is a full-screen terminal UI showing the files in a vault as a
+// tree, along with the metadata of the selected file.
+// It is drawn with ANSI escape sequences, redrawing the whole screen after
+// every event.
+
+const (
+	ansiAltScreen  = "\x1b[?1049h\x1b[?25l"
+	ansiMainScreen = "\x1b[?25h\x1b[?1049l"
+	ansiHome       = "\x1b[H"
+	ansiClearLine  = "\x1b[K"
+	ansiReverse    = "\x1b[7m"
+	ansiReset      = "\x1b[0m"
+)
+
+// browserMode determines what the browser displays and how keys are handled.
+type browserMode int
+
+const (
+	modeTree browserMode = iota
+	// picking a local file to add to the vault
+	modePicker
+	// reading a line of input (e.g. the new name of a file)
+	modeInput
+	// asking for confirmation of an action
+	modeConfirm
+)
+
+// treeRow is a (visible) row of the tree of files in the vault.
+type treeRow struct {
+	name  string
+	depth int
+	dir   bool
+	// entry is the latest version of the file, for files
+	entry *AESVaultEntry
+}
+
+// pickItem is a local file shown by the file picker.
+type pickItem struct {
+	name string
+	dir  bool
+}
+
+type browser struct {
+	v      *AESVault
+	name   string
+	fd     int
+	width  int
+	height int
+	mode   browserMode
+	status string
+	quit   bool
+	// tree of files in the vault
+	expanded map[string]bool
+	rows     []treeRow
+	sel, top int
+	// line input and confirmation
+	prompt  string
+	input   []rune
+	onInput func(value string)
+	// file picker
+	pickDir      string
+	pickItems    []pickItem
+	pickSel      int
+	pickTop      int
+	pickTarget   string
+	pickPrevious string
+	// integrity test
+	verifying bool
+	// cancelVerify stops the running integrity test
+	cancelVerify context.CancelFunc
+	progress     chan [2]int
+	verified     chan []EntryIntegrity
+	done, total  int
+	results      map[string]string
+}
+
+// runBrowser runs the browser on the (open) vault with the given name until
+// the user quits.
+func runBrowser(name string, v *AESVault) error {
+	fd := int(syscall.Stdin)
+	if !terminal.IsTerminal(fd) {
+		return fmt.Errorf("the browser requires a terminal")
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("error setting up terminal: %s", err.Error())
+	}
+	defer terminal.Restore(fd, state)
+	fmt.Print(ansiAltScreen)
+	defer fmt.Print(ansiMainScreen)
+
+	b := &browser{
+		v:        v,
+		name:     name,
+		fd:       fd,
+		expanded: make(map[string]bool),
+		progress: make(chan [2]int, 1),
+		verified: make(chan []EntryIntegrity, 1),
+		status:   "Press q to quit.",
+	}
+	b.refresh()
+	keys := make(chan string, 16)
+	go readKeys(os.Stdin, keys)
+	// redraw periodically to pick up changes of the terminal's size
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for !b.quit {
+		b.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				b.quit = true
+				break
+			}
+			b.handleKey(key)
+		case p := <-b.progress:
+			b.done, b.total = p[0], p[1]
+		case result := <-b.verified:
+			b.finishVerify(result)
+		case <-ticker.C:
+		}
+	}
+	if b.verifying {
+		b.cancelVerify()
+		<-b.verified
+	}
+	return nil
+}
+
+// readKeys reads key presses from r and sends them to keys, as the printable
+// character or the name of the key ("up", "enter" etc.).
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+	}
+}
+
+var escapeKeys = map[string]string{
+	"\x1b[A": "up", "\x1bOA": "up",
+	"\x1b[B": "down", "\x1bOB": "down",
+	"\x1b[C": "right", "\x1bOC": "right",
+	"\x1b[D": "left", "\x1bOD": "left",
+	"\x1b[H": "home", "\x1bOH": "home", "\x1b[1~": "home",
+	"\x1b[F": "end", "\x1bOF": "end", "\x1b[4~": "end",
+	"\x1b[5~": "pgup",
+	"\x1b[6~": "pgdn",
+	"\x1b[3~": "delete",
+}
+
+// parseKeys splits the input read from the terminal into key presses.
+func parseKeys(buf []byte) []string {
+	var keys []string
+	for len(buf) > 0 {
+		switch c := buf[0]; {
+		case c == 0x1b && len(buf) > 2 && (buf[1] == '[' || buf[1] == 'O'):
+			// escape sequences end with a byte in the range 0x40-0x7e
+			end := 2
+			for end < len(buf)-1 && (buf[end] < 0x40 || buf[end] > 0x7e) {
+				end++
+			}
+			if key, ok := escapeKeys[string(buf[:end+1])]; ok {
+				keys = append(keys, key)
+			}
+			buf = buf[end+1:]
+			continue
+		case c == 0x1b:
+			keys = append(keys, "esc")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+		case c == '\t':
+			keys = append(keys, "tab")
+		case c < 0x20:
+		default:
+			r, size := utf8.DecodeRune(buf)
+			keys = append(keys, string(r))
+			buf = buf[size:]
+			continue
+		}
+		buf = buf[1:]
+	}
+	return keys
+}
+
+// refresh rebuilds the rows of the tree, after the vault was modified or a
+// directory was expanded or collapsed.
+func (b *browser) refresh() {
+	b.rows = nil
+	b.addRows("", 0)
+	if b.sel >= len(b.rows) {
+		b.sel = len(b.rows) - 1
+	}
+	if b.sel < 0 {
+		b.sel = 0
+	}
+}
+
+func (b *browser) addRows(dir string, depth int) {
+	for _, child := range b.v.dirChildren(dir) {
+		if strings.HasSuffix(child, "/") {
+			name := path.Join(dir, strings.TrimSuffix(child, "/"))
+			b.rows = append(b.rows, treeRow{name: name, depth: depth, dir: true})
+			if b.expanded[name] {
+				b.addRows(name, depth+1)
+			}
+			continue
+		}
+		name := path.Join(dir, child)
+		_, entry := b.v.lookupFile(name)
+		b.rows = append(b.rows, treeRow{name: name, depth: depth, entry: entry})
+	}
+}
+
+// selected returns the selected row of the tree, or nil if the vault is empty.
+func (b *browser) selected() *treeRow {
+	if b.sel < len(b.rows) {
+		return &b.rows[b.sel]
+	}
+	return nil
+}
+
+// selectName selects the row with the given name, if it is visible.
+func (b *browser) selectName(name string) {
+	for i, row := range b.rows {
+		if row.name == name {
+			b.sel = i
+		}
+	}
+}
+
+// targetDir returns the directory in the vault which files are added to: the
+// selected directory, or the directory of the selected file.
+func (b *browser) targetDir() string {
+	row := b.selected()
+	switch {
+	case row == nil:
+		return ""
+	case row.dir:
+		return row.name
+	}
+	if dir := path.Dir(row.name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// save writes the vault to disk after it has been modified.
+func (b *browser) save() {
+	if err := b.v.Close(); err != nil {
+		b.status = fmt.Sprintf("vault save error: %s", err.Error())
+	}
+}
+
+func (b *browser) handleKey(key string) {
+	switch b.mode {
+	case modeInput:
+		b.handleInputKey(key)
+	case modeConfirm:
+		b.mode = modeTree
+		if key == "y" || key == "Y" {
+			b.onInput("y")
+		} else {
+			b.status = "Cancelled."
+		}
+	case modePicker:
+		b.handlePickerKey(key)
+	default:
+		b.handleTreeKey(key)
+	}
+}
+
+// moveSelection moves the selection by delta rows, within [0, n).
+func moveSelection(sel, delta, n int) int {
+	sel += delta
+	if sel >= n {
+		sel = n - 1
+	}
+	if sel < 0 {
+		sel = 0
+	}
+	return sel
+}
+
+func (b *browser) handleTreeKey(key string) {
+	page := b.bodyHeight()
+	switch key {
+	case "up", "k":
+		b.sel = moveSelection(b.sel, -1, len(b.rows))
+	case "down", "j":
+		b.sel = moveSelection(b.sel, 1, len(b.rows))
+	case "pgup":
+		b.sel = moveSelection(b.sel, -page, len(b.rows))
+	case "pgdn":
+		b.sel = moveSelection(b.sel, page, len(b.rows))
+	case "home":
+		b.sel = 0
+	case "end":
+		b.sel = moveSelection(len(b.rows), -1, len(b.rows))
+	case "right", "enter", "l":
+		if row := b.selected(); row != nil && row.dir {
+			b.expanded[row.name] = !b.expanded[row.name] || key != "enter"
+			b.refresh()
+		}
+	case "left", "h":
+		row := b.selected()
+		if row == nil {
+			return
+		}
+		if row.dir && b.expanded[row.name] {
+			b.expanded[row.name] = false
+			b.refresh()
+		} else if dir := path.Dir(row.name); dir != "." {
+			// move to the parent directory
+			b.selectName(dir)
+		}
+	case "q", "ctrl-c", "esc":
+		b.quit = true
+		if b.verifying {
+			b.status = "Waiting for the integrity check to finish..."
+		}
+	case "v":
+		b.startVerify()
+	case "a", "x", "d", "r":
+		if b.verifying {
+			b.status = "Wait for the integrity check to finish."
+			return
+		}
+		switch key {
+		case "a":
+			b.openPicker()
+		case "x":
+			b.extract()
+		case "d":
+			b.remove()
+		case "r":
+			b.rename()
+		}
+	}
+}
+
+// ask reads a line of input, initially value, which is passed to fn.
+func (b *browser) ask(prompt, value string, fn func(value string)) {
+	b.mode = modeInput
+	b.prompt = prompt
+	b.input = []rune(value)
+	b.onInput = fn
+}
+
+// confirm asks for confirmation before running fn.
+func (b *browser) confirm(prompt string, fn func()) {
+	b.mode = modeConfirm
+	b.prompt = prompt + " [y/N] "
+	b.onInput = func(string) { fn() }
+}
+
+func (b *browser) handleInputKey(key string) {
+	switch key {
+	case "enter":
+		b.mode = modeTree
+		b.onInput(string(b.input))
+	case "esc", "ctrl-c":
+		b.mode = modeTree
+		b.status = "Cancelled."
+	case "backspace":
+		if len(b.input) > 0 {
+			b.input = b.input[:len(b.input)-1]
+		}
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			b.input = append(b.input, []rune(key)...)
+		}
+	}
+}
+
+func (b *browser) extract() {
+	selected := b.selected()
+	if selected == nil {
+		return
+	}
+	// the rows are rebuilt when the vault is modified
+	row := *selected
+	b.ask("Extract to: ", path.Base(row.name), func(output string) {
+		var err error
+		if row.dir {
+			err = b.v.RetrieveDir(row.name, output)
+		} else {
+			err = b.v.RetrieveFile(row.name, output)
+		}
+		if err != nil {
+			b.status = fmt.Sprintf("Extracting '%s' failed: %s", row.name, err.Error())
+			return
+		}
+		b.status = fmt.Sprintf("Extracted '%s' to '%s'.", row.name, output)
+	})
+}
+
+func (b *browser) remove() {
+	selected := b.selected()
+	if selected == nil {
+		return
+	}
+	// the rows are rebuilt when the vault is modified
+	row := *selected
+	prompt := fmt.Sprintf("Delete '%s' (all versions)?", row.name)
+	if row.dir {
+		prompt = fmt.Sprintf("Delete all the files under '%s/'?", row.name)
+	}
+	b.confirm(prompt, func() {
+		var err error
+		if row.dir {
+			err = b.v.RemoveDir(row.name)
+		} else {
+			err = b.v.RemoveFile(row.name)
+		}
+		b.save()
+		b.refresh()
+		if err != nil {
+			b.status = fmt.Sprintf("Deleting '%s' failed: %s", row.name, err.Error())
+			return
+		}
+		b.status = fmt.Sprintf("Deleted '%s'.", row.name)
+	})
+}
+
+func (b *browser) rename() {
+	selected := b.selected()
+	if selected == nil {
+		return
+	}
+	// the rows are rebuilt when the vault is modified
+	row := *selected
+	b.ask("Rename to: ", row.name, func(newName string) {
+		err := b.v.Rename(row.name, newName)
+		if err != nil {
+			b.status = fmt.Sprintf("Renaming '%s' failed: %s", row.name, err.Error())
+			return
+		}
+		b.save()
+		newName = cleanEntryName(newName)
+		b.expandTo(newName)
+		b.refresh()
+		b.selectName(newName)
+		b.status = fmt.Sprintf("Renamed '%s' to '%s'.", row.name, newName)
+	})
+}
+
+// expandTo expands the directories containing name, so that it is visible.
+func (b *browser) expandTo(name string) {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		b.expanded[dir] = true
+	}
+}
+
+func (b *browser) startVerify() {
+	if b.verifying {
+		return
+	}
+	b.verifying = true
+	b.done, b.total = 0, len(b.v.Files)
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancelVerify = cancel
+	go func() {
+		results, _ := b.v.Verify(ctx, IntegrityOptions{OnResult: func(_ EntryIntegrity, done, total int) {
+			// only the latest progress is of interest
+			select {
+			case <-b.progress:
+			default:
+			}
+			b.progress <- [2]int{done, total}
+		}})
+		b.verified <- results
+	}()
+}
+
+func (b *browser) finishVerify(results []EntryIntegrity) {
+	b.verifying = false
+	b.results = make(map[string]string)
+	// a file is reported as failed if any of its versions failed, and as
+	// inconclusive if any of them could not be tested
+	severity := map[string]int{statusPassed: 0, statusInconclusive: 1, statusFailed: 2}
+	counts := make(map[string]int)
+	for _, r := range results {
+		status := r.Status
+		if status != statusPassed && status != statusFailed {
+			status = statusInconclusive
+		}
+		counts[status]++
+		if prev, ok := b.results[r.Entry.Filename]; !ok || severity[status] > severity[prev] {
+			b.results[r.Entry.Filename] = status
+		}
+	}
+	b.status = fmt.Sprintf("Integrity check: %d passed, %d failed, %d inconclusive.",
+		counts[statusPassed], counts[statusFailed], counts[statusInconclusive])
+}
+
+// openPicker shows the file picker, to add a local file to the vault.
+func (b *browser) openPicker() {
+	dir, err := os.Getwd()
+	if err != nil {
+		b.status = err.Error()
+		return
+	}
+	b.pickTarget = b.targetDir()
+	b.loadPicker(dir)
+	b.mode = modePicker
+}
+
+func (b *browser) loadPicker(dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		b.status = fmt.Sprintf("error reading '%s': %s", dir, err.Error())
+		return
+	}
+	b.pickDir = dir
+	b.pickItems = []pickItem{{name: "..", dir: true}}
+	for _, info := range files {
+		b.pickItems = append(b.pickItems, pickItem{name: info.Name(), dir: info.IsDir()})
+	}
+	sort.SliceStable(b.pickItems[1:], func(i, j int) bool {
+		a, c := b.pickItems[i+1], b.pickItems[j+1]
+		if a.dir != c.dir {
+			return a.dir
+		}
+		return a.name < c.name
+	})
+	b.pickSel, b.pickTop = 0, 0
+	// select the directory which was left, when moving to the parent
+	for i, item := range b.pickItems {
+		if item.name == b.pickPrevious {
+			b.pickSel = i
+		}
+	}
+	b.pickPrevious = ""
+}
+
+func (b *browser) handlePickerKey(key string) {
+	page := b.bodyHeight()
+	item := b.pickItems[b.pickSel]
+	switch key {
+	case "up", "k":
+		b.pickSel = moveSelection(b.pickSel, -1, len(b.pickItems))
+	case "down", "j":
+		b.pickSel = moveSelection(b.pickSel, 1, len(b.pickItems))
+	case "pgup":
+		b.pickSel = moveSelection(b.pickSel, -page, len(b.pickItems))
+	case "pgdn":
+		b.pickSel = moveSelection(b.pickSel, page, len(b.pickItems))
+	case "left", "backspace", "h":
+		b.pickPrevious = filepath.Base(b.pickDir)
+		b.loadPicker(filepath.Dir(b.pickDir))
+	case "enter", "right", "l":
+		if item.dir {
+			b.loadPicker(filepath.Join(b.pickDir, item.name))
+		} else if key == "enter" {
+			b.addPicked(item)
+		}
+	case "a":
+		if item.name != ".." {
+			b.addPicked(item)
+		}
+	case "esc", "q", "ctrl-c":
+		b.mode = modeTree
+	}
+}
+
+// addPicked adds the local file (or directory) selected in the picker to the
+// vault.
+func (b *browser) addPicked(item pickItem) {
+	src := filepath.Join(b.pickDir, item.name)
+	name := cleanEntryName(path.Join(b.pickTarget, item.name))
+	var err error
+	if item.dir {
+		report := b.v.addDir(src, name)
+		if err = report.Err(); err != nil && len(report.Succeeded) != 0 {
+			err = fmt.Errorf("%s (%d were added)", err.Error(), len(report.Succeeded))
+		}
+	} else {
+		err = b.v.addFile(src, name)
+	}
+	b.save()
+	b.mode = modeTree
+	b.expandTo(name)
+	b.refresh()
+	b.selectName(name)
+	if err != nil {
+		b.status = fmt.Sprintf("Adding '%s' failed: %s", src, err.Error())
+		return
+	}
+	b.status = fmt.Sprintf("Added '%s' as '%s'.", src, name)
+}
+
+// bodyHeight returns the number of rows of the panes.
+func (b *browser) bodyHeight() int {
+	if h := b.height - 3; h > 1 {
+		return h
+	}
+	return 1
+}
+
+// fit truncates or pads s to exactly width (single-width) characters.
+func fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) > width {
+		return string(runes[:width-1]) + "~"
+	}
+	return s + strings.Repeat(" ", width-len(runes))
+}
+
+// scroll returns the first visible row of a list, keeping the selected row sel
+// visible in height rows.
+func scroll(top, sel, height int) int {
+	if sel < top {
+		return sel
+	}
+	if sel >= top+height {
+		return sel - height + 1
+	}
+	return top
+}
+
+func (b *browser) draw() {
+	b.width, b.height = 80, 24
+	if w, h, err := terminal.GetSize(b.fd); err == nil && w > 0 && h > 0 {
+		b.width, b.height = w, h
+	}
+	bodyHeight := b.bodyHeight()
+	leftWidth := b.width / 2
+	rightWidth := b.width - leftWidth - 1
+
+	var left, right []string
+	if b.mode == modePicker {
+		b.pickTop = scroll(b.pickTop, b.pickSel, bodyHeight)
+		for i := b.pickTop; i < len(b.pickItems) && i < b.pickTop+bodyHeight; i++ {
+			line := fit(" "+b.pickItems[i].name+dirSuffix(b.pickItems[i].dir), leftWidth)
+			if i == b.pickSel {
+				line = ansiReverse + line + ansiReset
+			}
+			left = append(left, line)
+		}
+		right = []string{
+			"Add to the vault directory:",
+			"  /" + b.pickTarget,
+			"",
+			"Local directory:",
+			"  " + b.pickDir,
+		}
+	} else {
+		b.top = scroll(b.top, b.sel, bodyHeight)
+		for i := b.top; i < len(b.rows) && i < b.top+bodyHeight; i++ {
+			line := fit(b.rowText(b.rows[i]), leftWidth)
+			if i == b.sel {
+				line = ansiReverse + line + ansiReset
+			}
+			left = append(left, line)
+		}
+		if len(b.rows) == 0 {
+			left = append(left, fit(" (the vault is empty, press a to add files)", leftWidth))
+		}
+		right = b.details()
+	}
+
+	var screen strings.Builder
+	screen.WriteString(ansiHome)
+	title := fmt.Sprintf(" gringotts: %s", b.name)
+	screen.WriteString(ansiReverse + fit(title, b.width) + ansiReset + "\r\n")
+	for i := 0; i < bodyHeight; i++ {
+		l, r := strings.Repeat(" ", leftWidth), ""
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		screen.WriteString(l + "|" + fit(" "+r, rightWidth) + ansiClearLine + "\r\n")
+	}
+	screen.WriteString(fit(b.statusLine(), b.width) + ansiClearLine + "\r\n")
+	screen.WriteString(ansiReverse + fit(b.helpLine(), b.width) + ansiReset)
+	fmt.Print(screen.String())
+}
+
+func dirSuffix(dir bool) string {
+	if dir {
+		return "/"
+	}
+	return ""
+}
+
+func (b *browser) rowText(row treeRow) string {
+	indent := strings.Repeat("  ", row.depth)
+	base := path.Base(row.name)
+	if row.dir {
+		marker := "+ "
+		if b.expanded[row.name] {
+			marker = "- "
+		}
+		return " " + indent + marker + base + "/"
+	}
+	text := " " + indent + "  " + base
+	if status := b.results[row.name]; status == "failed" || status == "inconclusive" {
+		text += " (" + status + ")"
+	}
+	return text
+}
+
+// details returns the lines describing the selected file or directory.
+func (b *browser) details() []string {
+	row := b.selected()
+	if row == nil {
+		return nil
+	}
+	if row.dir {
+		entries := b.v.entriesUnder(row.name)
+		var size int64
+		for _, entry := range entries {
+			size += entry.Size
+		}
+		return []string{
+			"Directory: " + row.name + "/",
+			fmt.Sprintf("Files:     %d", len(entries)),
+			fmt.Sprintf("Size:      %db", size),
+		}
+	}
+	entry := row.entry
+	versions, _ := b.v.History(entry.Filename)
+	orNone := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	mode, owner := "-", "-"
+	if entry.hasMode() {
+		mode = fmt.Sprintf("%#o", uint32(entry.Mode.Perm()))
+	}
+	if entry.Owner != nil {
+		owner = fmt.Sprintf("%d:%d", entry.Owner.Uid, entry.Owner.Gid)
+	}
+	var xattrs []string
+	for name := range entry.Xattrs {
+		xattrs = append(xattrs, name)
+	}
+	sort.Strings(xattrs)
+	lines := []string{
+		"Name:      " + entry.Filename,
+		fmt.Sprintf("Version:   %d of %d", entry.Version, len(versions)),
+		fmt.Sprintf("Size:      %db", entry.Size),
+		"Added:     " + formatTime(entry.Added),
+		"Mode:      " + mode,
+		"Modified:  " + formatTime(entry.ModTime),
+		"Owner:     " + owner,
+		"Xattrs:    " + orNone(strings.Join(xattrs, ", ")),
+		"Tags:      " + orNone(strings.Join(entry.Tags, ", ")),
+		"Note:      " + orNone(entry.Note),
+	}
+	var keys []string
+	for k := range entry.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		label := "           "
+		if i == 0 {
+			label = "Meta:      "
+		}
+		lines = append(lines, label+k+"="+entry.Meta[k])
+	}
+	if status, ok := b.results[entry.Filename]; ok {
+		lines = append(lines, "Integrity: "+status)
+	}
+	return lines
+}
+
+func (b *browser) statusLine() string {
+	switch {
+	case b.mode == modeInput:
+		return b.prompt + string(b.input) + "_"
+	case b.mode == modeConfirm:
+		return b.prompt
+	case b.verifying:
+		const barWidth = 30
+		filled := 0
+		if b.total > 0 {
+			filled = b.done * barWidth / b.total
+		}
+		return fmt.Sprintf("Verifying [%s%s] %d/%d", strings.Repeat("#", filled),
+			strings.Repeat(".", barWidth-filled), b.done, b.total)
+	}
+	return b.status
+}
+
+func (b *browser) helpLine() string {
+	switch b.mode {
+	case modeInput:
+		return " enter: confirm  esc: cancel"
+	case modeConfirm:
+		return " y: confirm  any other key: cancel"
+	case modePicker:
+		return " arrows: move  enter: open directory/add file  a: add selected  left: parent  esc: cancel"
+	}
+	return " arrows: move/expand  a: add  x: extract  d: delete  r: rename  v: verify  q: quit"
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		input string
+		keys  []string
+	}{
+		{"", nil},
+		{"q", []string{"q"}},
+		{"jk", []string{"j", "k"}},
+		{"\x1b[A\x1b[B", []string{"up", "down"}},
+		{"\x1bOA", []string{"up"}},
+		{"\x1b[3~", []string{"delete"}},
+		{"\x1b", []string{"esc"}},
+		{"\x1b[99~x", []string{"x"}},
+		{"\r\n\t\x7f\x08\x03", []string{"enter", "enter", "tab", "backspace", "backspace", "ctrl-c"}},
+		{"\x01a", []string{"a"}},
+		{"é✓", []string{"é", "✓"}},
+	}
+	for _, tc := range tests {
+		if keys := parseKeys([]byte(tc.input)); !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("parseKeys(%q) = %q, want %q", tc.input, keys, tc.keys)
+		}
+	}
+}
+
+func TestFinishVerify(t *testing.T) {
+	b := &browser{verifying: true}
+	entry := func(name string) *AESVaultEntry { return &AESVaultEntry{Filename: name} }
+	b.finishVerify([]EntryIntegrity{
+		{Entry: entry("a"), Status: statusPassed},
+		{Entry: entry("b"), Status: statusFailed},
+		{Entry: entry("b"), Status: statusPassed},
+		{Entry: entry("c"), Status: statusPassed},
+		{Entry: entry("c"), Status: statusInconclusive},
+		{Entry: entry("c"), Status: statusFailed},
+		{Entry: entry("d"), Status: statusInconclusive},
+	})
+	want := map[string]string{"a": statusPassed, "b": statusFailed, "c": statusFailed, "d": statusInconclusive}
+	for name, status := range want {
+		if b.results[name] != status {
+			t.Errorf("'%s' reported as %q, want %q", name, b.results[name], status)
+		}
+	}
+	if b.verifying || b.status != "Integrity check: 3 passed, 2 failed, 2 inconclusive." {
+		t.Errorf("status %q", b.status)
+	}
+}
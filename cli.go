@@ -0,0 +1,596 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Exit codes of the gringotts command.
+const (
+	exitOK    = 0
+	exitError = 1
+	// the command line was invalid (unknown command/flag, conflicting options,
+	// missing arguments etc.)
+	exitUsage = 2
+)
+
+// command is a gringotts subcommand, such as "add" or "ls".
+type command struct {
+	name string
+	// synopsis of the command's positional arguments
+	args    string
+	summary string
+	// detailed description of the command, displayed by "gringotts help"
+	help string
+	// setup defines the command's flags on fs and returns the function which
+	// runs the command with the positional arguments, once fs is parsed
+	setup func(fs *flag.FlagSet) func(args []string) error
+	// hidden commands are not listed in the help menu
+	hidden bool
+}
+
+// usageError is returned by commands when they are invoked incorrectly.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func usageErrorf(format string, a ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, a...)}
+}
+
+// lookupCommand returns the command with the given name, or nil if there is no
+// such command.
+func lookupCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// newFlagSet returns the flag set for the command, along with the function
+// which runs it.
+// Errors and usage messages are reported by the caller, rather than the flag
+// package.
+func newFlagSet(cmd *command) (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs, cmd.setup(fs)
+}
+
+// parseArgs parses the flags in args, which may be interspersed with the
+// positional arguments, and returns the positional arguments.
+// All arguments following "--" are treated as positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		// the flag package stops at the first positional argument (or "--")
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// run runs the gringotts command line args (excluding the program name) and
+// returns the exit code.
+func run(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	name := args[0]
+	if name == "-h" || name == "-help" || name == "--help" {
+		name = "help"
+	}
+	cmd := lookupCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "gringotts: unknown command '%s', use 'gringotts help'\n", name)
+		return exitUsage
+	}
+	fs, runCmd := newFlagSet(cmd)
+	positional, err := parseArgs(fs, args[1:])
+	if err == flag.ErrHelp {
+		commandUsage(cmd)
+		return exitOK
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "gringotts %s: %s, use 'gringotts help %s'\n", cmd.name, err.Error(), cmd.name)
+		return exitUsage
+	}
+	if err := runCmd(positional); err != nil {
+		fmt.Fprintf(os.Stderr, "gringotts %s: %s\n", cmd.name, err.Error())
+		if _, ok := err.(*usageError); ok {
+			return exitUsage
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+// openVault prompts for the password of the vault with the given name (see
+// resolveVault) and opens it.
+// If an agent is running, the key it holds for the vault is used instead, or
+// the key is stored in it once the password was entered.
+func openVault(name string) (*AESVault, error) {
+	name, dir, err := resolveVault(name)
+	if err != nil {
+		return nil, err
+	}
+	return unlockVault(name, dir, getPassword, os.Stderr)
+}
+
+// unlockVault opens the vault called name in the directory dir, using the key
+// held by the agent, or else the password returned by password (called with
+// the prompt), in which case the key is stored in the agent.
+// Failures to store the key are reported on warnings.
+func unlockVault(name, dir string, password func(prompt string) ([]byte, error), warnings io.Writer) (*AESVault, error) {
+	if v := agentOpen(dir); v != nil {
+		return v, nil
+	}
+	pwd, err := password(fmt.Sprintf("Enter password for '%s': ", name))
+	if err != nil {
+		return nil, fmt.Errorf("error reading password: %s", err.Error())
+	}
+	v, err := OpenAESVault(dir, pwd)
+	if err != nil {
+		return nil, fmt.Errorf("error opening '%s': %s", name, err.Error())
+	}
+	if err := agentStore(v); err != nil {
+		fmt.Fprintf(warnings, "gringotts: %s\n", err.Error())
+	}
+	return v, nil
+}
+
+// reviewVault is withVault for the commands with the --dry-run option (see
+// reviewFlags): for a dry run, the vault is not saved, so that nothing at all
+// is changed (not even the expired items of the trash deleted).
+func reviewVault(name string, dryRun bool, fn func(v *AESVault) error) error {
+	if !dryRun {
+		return withVault(name, fn)
+	}
+	v, err := openVault(name)
+	if err != nil {
+		return err
+	}
+	return fn(v)
+}
+
+// withVault opens the vault with the given name, runs fn on it and closes the
+// vault, saving any changes.
+// The vault is saved even if fn fails, since ciphertexts may have been added or
+// deleted before the failure.
+func withVault(name string, fn func(v *AESVault) error) error {
+	v, err := openVault(name)
+	if err != nil {
+		return err
+	}
+	err = fn(v)
+	if closeErr := v.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("vault save error: %s", closeErr.Error())
+	}
+	return err
+}
+
+// bulkResult reports the files which failed in a bulk operation on stderr,
+// returning an error if any did.
+func bulkResult(report *BulkReport) error {
+	if len(report.Failed) > 1 || len(report.Succeeded) != 0 {
+		for _, f := range report.Failed {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", f.Name, f.Err.Error())
+		}
+	}
+	return report.Err()
+}
+
+// interruptContext returns a context which is cancelled when the process is
+// interrupted (e.g. by Ctrl-C), and a function which stops listening for the
+// interrupt.
+// It lets long-running operations stop cleanly, so that the vault is saved.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
+
+// Helpers defining the flags shared by several commands follow.
+
+func vaultFlag(fs *flag.FlagSet) *string {
+	return fs.String("vault", "", "name (or path) of the vault to operate on; defaults to the configured default vault")
+}
+
+// jobsFlag defines the --jobs flag, and returns a function which returns the
+// number of files to process concurrently (or a usage error if it is invalid).
+func jobsFlag(fs *flag.FlagSet) func() (int, error) {
+	jobs := fs.Int("jobs", DefaultWorkers, "number of files processed concurrently")
+	return func() (int, error) {
+		if *jobs < 1 {
+			return 0, usageErrorf("invalid number of jobs %d", *jobs)
+		}
+		return *jobs, nil
+	}
+}
+
+func yesFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("yes", false, "do not ask for confirmation")
+}
+
+// reviewFlags defines the --dry-run and --interactive flags of the commands
+// which remove files.
+func reviewFlags(fs *flag.FlagSet) (dryRun, interactive *bool) {
+	dryRun = fs.Bool("dry-run", false, "show what would be removed, without removing anything")
+	interactive = fs.Bool("interactive", false, "ask for confirmation of each removal")
+	return dryRun, interactive
+}
+
+// confirmEntries asks the user to confirm the action on each of the entries
+// (see confirmEach), and returns the confirmed entries along with their
+// effects.
+func confirmEntries(action string, entries []*AESVaultEntry, effects []string) ([]*AESVaultEntry, []string) {
+	refs := make([]string, len(entries))
+	for i, entry := range entries {
+		refs[i] = fmt.Sprintf("%s@%d", entry.Filename, entry.Version)
+	}
+	var confirmed []*AESVaultEntry
+	var confirmedEffects []string
+	for _, i := range confirmEach(action, refs, effects) {
+		confirmed = append(confirmed, entries[i])
+		confirmedEffects = append(confirmedEffects, effects[i])
+	}
+	return confirmed, confirmedEffects
+}
+
+// confirmEach asks the user to confirm the action (e.g. "Remove") on each of
+// the items, described along with its effect, and returns the indices of the
+// confirmed items.
+func confirmEach(action string, items, effects []string) []int {
+	var confirmed []int
+	for i, item := range items {
+		if confirm(fmt.Sprintf("%s %s (%s)?", action, item, effects[i])) {
+			confirmed = append(confirmed, i)
+		}
+	}
+	return confirmed
+}
+
+// snapshotFlag defines the --at flag, and returns a function which returns the
+// vault (or a view of the selected snapshot of it) to operate on.
+func snapshotFlag(fs *flag.FlagSet) func(v *AESVault) (*AESVault, error) {
+	at := fs.String("at", "", "label of the snapshot to operate on")
+	return func(v *AESVault) (*AESVault, error) {
+		if *at == "" {
+			return v, nil
+		}
+		return v.SnapshotView(*at)
+	}
+}
+
+// metadataFlags defines the flags selecting the file metadata to record or
+// restore, and returns a function which returns the selected options.
+func metadataFlags(fs *flag.FlagSet) func() MetadataOptions {
+	noMode := fs.Bool("no-mode", false, "do not record/restore file permissions")
+	noMtime := fs.Bool("no-mtime", false, "do not record/restore file modification times")
+	noXattrs := fs.Bool("no-xattrs", false, "do not record/restore extended attributes")
+	owner := fs.Bool("owner", false, "record/restore file ownership")
+	return func() MetadataOptions {
+		return MetadataOptions{
+			Mode:    !*noMode,
+			ModTime: !*noMtime,
+			Owner:   *owner,
+			Xattrs:  !*noXattrs,
+		}
+	}
+}
+
+// annotationFlags defines the flags annotating files, and returns a function
+// which returns the annotation described by them.
+func annotationFlags(fs *flag.FlagSet) func() Annotation {
+	tags := fs.String("tags", "", "comma-separated tags to add")
+	untag := fs.String("untag", "", "comma-separated tags to remove")
+	note := fs.String("note", "", "note to attach (an empty note removes it)")
+	meta := make(keyValueFlag)
+	fs.Var(meta, "meta", "custom metadata (key=value) to set; an empty value removes the key; can be repeated")
+	return func() Annotation {
+		a := Annotation{
+			AddTags:    splitList(*tags),
+			RemoveTags: splitList(*untag),
+			Meta:       meta,
+		}
+		if isFlagSet(fs, "note") {
+			a.Note = note
+		}
+		return a
+	}
+}
+
+// filterFlags defines the flags filtering entries, and returns a function
+// which returns the filter described by them.
+func filterFlags(fs *flag.FlagSet) func() (EntryFilter, error) {
+	match := fs.String("match", "", "glob pattern that files must match ('*' does not match '/')")
+	regex := fs.String("regex", "", "regular expression that files must match")
+	minSize := fs.String("min-size", "", "minimum size of files (e.g. 10K, 5M)")
+	maxSize := fs.String("max-size", "", "maximum size of files (e.g. 10K, 5M)")
+	since := fs.String("since", "", "only files added on/after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only files added before this date (YYYY-MM-DD)")
+	tags := fs.String("tags", "", "comma-separated tags that files must have")
+	meta := make(keyValueFlag)
+	fs.Var(meta, "meta", "custom metadata (key=value) that files must have; can be repeated")
+	return func() (EntryFilter, error) {
+		var err error
+		filter := EntryFilter{Pattern: *match, Tags: splitList(*tags), Meta: meta}
+		if *regex != "" {
+			if filter.Regexp, err = regexp.Compile(*regex); err != nil {
+				return filter, usageErrorf("invalid regex: %s", err.Error())
+			}
+		}
+		if filter.MinSize, err = parseSize(*minSize); err != nil {
+			return filter, err
+		}
+		if filter.MaxSize, err = parseSize(*maxSize); err != nil {
+			return filter, err
+		}
+		if filter.Since, err = parseDate(*since); err != nil {
+			return filter, err
+		}
+		if filter.Until, err = parseDate(*until); err != nil {
+			return filter, err
+		}
+		// files added on the "until" date are excluded
+		if !filter.Until.IsZero() {
+			filter.Until = filter.Until.Add(-time.Nanosecond)
+		}
+		return filter, nil
+	}
+}
+
+// keyValueFlag is a flag which collects "key=value" pairs and can be repeated.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	var pairs []string
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(s string) error {
+	idx := strings.Index(s, "=")
+	if idx <= 0 {
+		return fmt.Errorf("expected key=value, got '%s'", s)
+	}
+	f[s[:idx]] = s[idx+1:]
+	return nil
+}
+
+// isFlagSet reports whether the flag with the given name was set on the
+// command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList splits a comma-separated list, ignoring empty elements.
+func splitList(s string) []string {
+	var elems []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// parseSize parses a size in bytes, with an optional K, M, G or T (binary)
+// suffix.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	digits := s
+	if idx := strings.IndexAny(s, "KMGTkmgt"); idx != -1 && idx == len(s)-1 {
+		mult = 1 << (10 * (strings.Index("KMGT", strings.ToUpper(s[idx:])) + 1))
+		digits = s[:idx]
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, usageErrorf("invalid size '%s'", s)
+	}
+	if n > math.MaxInt64/mult {
+		return 0, usageErrorf("size '%s' is too large", s)
+	}
+	return n * mult, nil
+}
+
+// parseDate parses a date (YYYY-MM-DD, in local time) or an RFC 3339 time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, usageErrorf("invalid date '%s'", s)
+	}
+	return t, nil
+}
+
+// matchEntries returns the latest version of the files matching the glob
+// pattern, once the user confirms (unless yes is set) that the action should be
+// performed on them.
+// If the user does not confirm, no entries are returned.
+func matchEntries(v *AESVault, pattern, action string, yes bool) ([]*AESVaultEntry, error) {
+	matches, err := v.FindFiles(EntryFilter{Pattern: pattern})
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no entries match '%s'", pattern)
+	}
+	if yes {
+		return matches, nil
+	}
+	fmt.Fprintf(os.Stderr, "The following files will be %s:\n", action)
+	for _, entry := range matches {
+		fmt.Fprintf(os.Stderr, "%s\n", entry.Filename)
+	}
+	if !confirm("Continue?") {
+		return nil, nil
+	}
+	return matches, nil
+}
+
+// editorCommand returns the command line of the user's editor, as given by
+// $VISUAL or $EDITOR.
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) != 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// runEditor runs the user's editor on the file at p, waiting for it to exit.
+func runEditor(p string) error {
+	editor := editorCommand()
+	cmd := exec.Command(editor[0], append(editor[1:], p)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor '%s' failed: %s", strings.Join(editor, " "), err.Error())
+	}
+	return nil
+}
+
+// pagerCommand returns the command line of the user's pager, as given by
+// $PAGER.
+func pagerCommand() []string {
+	if fields := strings.Fields(os.Getenv("PAGER")); len(fields) != 0 {
+		return fields
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"more"}
+	}
+	return []string{"less"}
+}
+
+// runPager runs the user's pager, calling fn to write the text to display to
+// it, and waits for the pager to exit.
+func runPager(fn func(w io.Writer) error) error {
+	pager := pagerCommand()
+	cmd := exec.Command(pager[0], pager[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("pager '%s' failed: %s", strings.Join(pager, " "), err.Error())
+	}
+	// the pager may be quit before all the text was written to it
+	w := bufio.NewWriter(&quietWriter{w: in})
+	err = fn(w)
+	if flushErr := w.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	in.Close()
+	if waitErr := cmd.Wait(); waitErr != nil && err == nil {
+		err = fmt.Errorf("pager '%s' failed: %s", strings.Join(pager, " "), waitErr.Error())
+	}
+	return err
+}
+
+// quietWriter discards everything written to it once writing to the
+// underlying writer fails.
+type quietWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+func (q *quietWriter) Write(p []byte) (int, error) {
+	if !q.failed {
+		if _, err := q.w.Write(p); err != nil {
+			q.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+// streamFile writes the contents of the named file in v to w.
+// If guard is set, the file is refused if it appears to be binary.
+func streamFile(v *AESVault, name string, w io.Writer, guard bool) error {
+	if !guard {
+		return v.StreamFile(name, w)
+	}
+	g := newBinaryGuard(w)
+	err := v.StreamFile(name, g)
+	if err == nil {
+		err = g.Flush()
+	}
+	if g.Refused {
+		return fmt.Errorf("'%s' appears to be binary, use --force to display it anyway", name)
+	}
+	return err
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	return terminal.IsTerminal(int(f.Fd()))
+}
+
+// formatTime formats t for display, or returns "-" if t is the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"100", 100},
+		{"2K", 2 << 10},
+		{"3m", 3 << 20},
+		{"1G", 1 << 30},
+		{"8388607T", 8388607 << 40},
+	} {
+		if got, err := parseSize(test.s); err != nil || got != test.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", test.s, got, err, test.want)
+		}
+	}
+	for _, s := range []string{"-1", "K", "1.5M", "10KB", "1X", "8388608T", "9223372036854775808"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	if got, err := parseDate("2020-02-29"); err != nil || !got.Equal(time.Date(2020, 2, 29, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("parseDate(\"2020-02-29\") = %v, %v", got, err)
+	}
+	if got, err := parseDate("2020-02-29T12:00:00Z"); err != nil || !got.Equal(time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("parseDate(\"2020-02-29T12:00:00Z\") = %v, %v", got, err)
+	}
+	for _, s := range []string{"2020-02-30", "yesterday", "29/02/2020"} {
+		if _, err := parseDate(s); err == nil {
+			t.Errorf("parseDate(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,1704 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commands lists the gringotts subcommands, in the order they are displayed in
+// the help menu.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		helpCmd,
+		createCmd,
+		vaultCmd,
+		lsCmd,
+		addCmd,
+		getCmd,
+		catCmd,
+		viewCmd,
+		editCmd,
+		rmCmd,
+		mvCmd,
+		historyCmd,
+		tagCmd,
+		searchCmd,
+		snapshotCmd,
+		trashCmd,
+		setCmd,
+		infoCmd,
+		shellCmd,
+		browseCmd,
+		agentCmd,
+		lockCmd,
+		verifyCmd,
+		repairCmd,
+		scrubCmd,
+		healthCmd,
+		fsckCmd,
+		gcCmd,
+		pruneCmd,
+		completionCmd,
+		completeCmd,
+	}
+}
+
+var helpCmd = &command{
+	name:    "help",
+	args:    "[command]",
+	summary: "display help for gringotts or one of its commands",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			if len(args) == 0 {
+				usage()
+				return nil
+			}
+			cmd := lookupCommand(args[0])
+			if cmd == nil {
+				return usageErrorf("unknown command '%s'", args[0])
+			}
+			commandUsage(cmd)
+			return nil
+		}
+	},
+}
+
+var createCmd = &command{
+	name:    "create",
+	args:    "<vault>",
+	summary: "create a new vault",
+	help: `Creates a new vault at the specified path.
+The vault is a directory, containing the vault file (vault.bin), its encryption
+parameters (params.json) and the ciphertexts of the files added to it.
+The user is prompted for the password of the vault.
+
+The cipher and key derivation function default to the ones set in the config
+file (AES-256 and scrypt, if not set). With --register, the vault is also
+registered in the config file under the given name, so that it can be used
+from any directory with "--vault <name>".`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		cipher := fs.String("cipher", config.Cipher, "cipher of the vault: aes-256, aes-192 or aes-128")
+		kdf := fs.String("kdf", config.KDF, "key derivation function: scrypt or sha256")
+		register := fs.String("register", "", "register the vault under this name")
+		return func(args []string) error {
+			if len(args) != 1 {
+				return usageErrorf("expected the name of the vault to create")
+			}
+			vaultName := args[0]
+			params, err := NewVaultParams(*cipher, *kdf)
+			if err != nil {
+				return usageErrorf("%s", err.Error())
+			}
+			pwd, err := getPassword(fmt.Sprintf("Enter a password for '%s': ", vaultName))
+			if err != nil {
+				return fmt.Errorf("error reading password: %s", err.Error())
+			}
+			v, err := NewAESVault(vaultName, params, pwd)
+			if err != nil {
+				return fmt.Errorf("error creating vault: %s", err.Error())
+			}
+			if err := v.Close(); err != nil {
+				return fmt.Errorf("error closing vault: %s", err.Error())
+			}
+			if *register != "" {
+				return registerVault(*register, vaultName)
+			}
+			return nil
+		}
+	},
+}
+
+var vaultCmd = &command{
+	name:    "vault",
+	args:    "list | add <name> <path> | remove <name> | default [<name>]",
+	summary: "manage the registered vaults",
+	help: `Manages the vaults registered in the config file, which can be used from any
+directory with "--vault <name>":
+  list                  list the registered vaults (* marks the default vault)
+  add <name> <path>     register the vault at path under name
+  remove <name>         unregister the vault (its files are not deleted)
+  default [<name>]      set the vault used when --vault is not given, or unset
+                        it if no name is given
+
+The config file is stored at $GRINGOTTS_CONFIG, or in the user's configuration
+directory ($XDG_CONFIG_HOME/gringotts/config.json or ~/.config/gringotts/config.json
+on Linux).`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected a vault action")
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			action, args := args[0], args[1:]
+			switch {
+			case action == "list" && len(args) == 0:
+				list := []registeredVaultJSON{}
+				for _, name := range registeredVaults() {
+					list = append(list, registeredVaultJSON{Name: name, Path: config.Vaults[name], Default: name == config.DefaultVault})
+				}
+				items := make([]interface{}, len(list))
+				for i := range list {
+					items[i] = list[i]
+				}
+				return printList(format, map[string][]registeredVaultJSON{"vaults": list}, items, func() {
+					for _, vault := range list {
+						mark := " "
+						if vault.Default {
+							mark = "*"
+						}
+						fmt.Printf("%s %s %s\n", mark, vault.Name, vault.Path)
+					}
+				})
+			case action == "add" && len(args) == 2:
+				if _, err := os.Stat(filepath.Join(args[1], strings.TrimPrefix(vaultFile, "/"))); err != nil {
+					return fmt.Errorf("'%s' is not a vault", args[1])
+				}
+				return registerVault(args[0], args[1])
+			case action == "remove" && len(args) == 1:
+				if _, ok := config.Vaults[args[0]]; !ok {
+					return fmt.Errorf("no vault registered as '%s'", args[0])
+				}
+				delete(config.Vaults, args[0])
+				if config.DefaultVault == args[0] {
+					config.DefaultVault = ""
+				}
+				return saveConfig()
+			case action == "default" && len(args) <= 1:
+				config.DefaultVault = ""
+				if len(args) == 1 {
+					if _, ok := config.Vaults[args[0]]; !ok {
+						return fmt.Errorf("no vault registered as '%s'", args[0])
+					}
+					config.DefaultVault = args[0]
+				}
+				return saveConfig()
+			}
+			return usageErrorf("invalid vault action '%s'", strings.Join(append([]string{action}, args...), " "))
+		}
+	},
+}
+
+var lsCmd = &command{
+	name:    "ls",
+	summary: "list the files in a vault",
+	help: `Lists the files in the vault (the latest version of each), along with their
+sizes and tags.
+The files listed can be filtered by name, size, date and annotations, and
+sorted.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		at := snapshotFlag(fs)
+		filterOpts := filterFlags(fs)
+		sortBy := fs.String("sort", "", "sort files by name, size or date (added)")
+		reverse := fs.Bool("reverse", false, "list files in reverse order")
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			filter, err := filterOpts()
+			if err != nil {
+				return err
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				files, err := at(v)
+				if err != nil {
+					return err
+				}
+				entries, err := files.FindFiles(filter)
+				if err != nil {
+					return err
+				}
+				if *sortBy != "" || *reverse {
+					if *sortBy == "" {
+						*sortBy = "name"
+					}
+					if err := SortEntries(entries, *sortBy, *reverse); err != nil {
+						return err
+					}
+				}
+				return printEntries(format, entries, func(entry *AESVaultEntry) {
+					if len(entry.Tags) == 0 {
+						fmt.Printf("%s %db\n", entry.Name(), entry.FileSize())
+					} else {
+						fmt.Printf("%s %db [%s]\n", entry.Name(), entry.FileSize(), strings.Join(entry.Tags, ","))
+					}
+				})
+			})
+		}
+	},
+}
+
+var addCmd = &command{
+	name:    "add",
+	args:    "<file>...",
+	summary: "encrypt files and add them to a vault",
+	help: `Encrypts and adds the specified files to the vault.
+Each file is named after its base name in the vault.
+If the vault already contains a file with the same name, the file is added as a
+new version and the previous versions are kept.
+
+Directories are added with --recursive: all the files in the directory tree are
+added, named by their path relative to the parent of the directory (for
+example, "add --recursive path/to/docs" adds "docs/a.txt", "docs/b/c.txt" etc.).
+
+The permissions, modification time and extended attributes (in the "user."
+namespace) of the files are recorded, unless disabled with the options below.
+
+The files can be annotated with tags, a note and custom metadata; new versions
+of a file keep the annotations of the previous version.
+
+Files are encrypted concurrently (see --jobs). If some files cannot be added,
+the others are still added, and the failures are reported at the end.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		recursive := fs.Bool("recursive", false, "add directories recursively")
+		jobs := jobsFlag(fs)
+		metadata := metadataFlags(fs)
+		annotation := annotationFlags(fs)
+		return func(args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected files to add")
+			}
+			n, err := jobs()
+			if err != nil {
+				return err
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				v.SetMetadataOptions(metadata())
+				v.SetAddAnnotation(annotation())
+				return bulkResult(v.AddFiles(args, *recursive, n))
+			})
+		}
+	},
+}
+
+var getCmd = &command{
+	name:    "get",
+	args:    "<name>...",
+	summary: "decrypt files from a vault",
+	help: `Decrypts the latest version of the named files in the vault and saves them in
+the current directory under their original (base) names.
+An older version of a file can be decrypted using "<name>@<version>".
+
+If a name is instead a directory in the vault, all the files under it are
+decrypted, recreating the directory tree; the name "." refers to the root of
+the vault.
+If a name is a glob pattern (such as "invoices/*.pdf"), all the matching files
+are decrypted, after asking for confirmation, and saved under their paths in
+the vault.
+
+The output file is truncated if it exists.
+The recorded metadata of the files is restored, unless disabled with the
+options below.
+
+Files are decrypted concurrently (see --jobs). If some files cannot be
+decrypted, the others still are, and the failures are reported at the end.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		at := snapshotFlag(fs)
+		output := fs.String("output", "", "name of the file (or directory, for trees/globs) to save the decrypted file(s) as")
+		yes := yesFlag(fs)
+		jobs := jobsFlag(fs)
+		metadata := metadataFlags(fs)
+		return func(args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected files to decrypt")
+			}
+			if len(args) > 1 && *output != "" {
+				return usageErrorf("--output cannot be used when decrypting multiple files")
+			}
+			n, err := jobs()
+			if err != nil {
+				return err
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				v.SetMetadataOptions(metadata())
+				files, err := at(v)
+				if err != nil {
+					return err
+				}
+				var retrievals []Retrieval
+				for _, name := range args {
+					var r []Retrieval
+					switch entry := files.resolveFile(name); {
+					case entry != nil:
+						out := *output
+						if out == "" {
+							out = path.Base(entry.Filename)
+						}
+						r = []Retrieval{{Entry: entry, Output: out}}
+					case isGlob(name):
+						var matches []*AESVaultEntry
+						if matches, err = matchEntries(files, name, "decrypted", *yes); err == nil {
+							r, err = treeRetrievals(matches, "", *output)
+						}
+					default:
+						r, err = files.dirRetrievals(name, *output)
+					}
+					if err != nil {
+						return err
+					}
+					retrievals = append(retrievals, r...)
+				}
+				return bulkResult(files.RetrieveAll(retrievals, n))
+			})
+		}
+	},
+}
+
+var catCmd = &command{
+	name:    "cat",
+	args:    "<name>...",
+	summary: "write the contents of files in a vault to stdout",
+	help: `Decrypts the named files and writes their contents to stdout, one after the
+other, without writing the plaintext to disk.
+An older version of a file can be displayed using "<name>@<version>".
+If stdout is a terminal, files which appear to be binary are refused, unless
+--force is given.
+Since the contents are written as they are decrypted, the ciphertext is only
+authenticated once a file has been written: if the command fails, the output
+must not be trusted.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		at := snapshotFlag(fs)
+		force := fs.Bool("force", false, "write binary files to a terminal")
+		return func(args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected files to display")
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				files, err := at(v)
+				if err != nil {
+					return err
+				}
+				guard := !*force && isTerminal(os.Stdout)
+				w := bufio.NewWriter(os.Stdout)
+				defer w.Flush()
+				for _, name := range args {
+					if err := streamFile(files, name, w, guard); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var viewCmd = &command{
+	name:    "view",
+	args:    "<name>",
+	summary: "display a file in a vault with $PAGER",
+	help: `Decrypts the named file and displays it with the pager given by $PAGER (less,
+by default), without writing the plaintext to disk.
+An older version of a file can be displayed using "<name>@<version>".
+Files which appear to be binary are refused, unless --force is given.
+If stdout is not a terminal, the file is written to it directly, as with
+"gringotts cat".`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		at := snapshotFlag(fs)
+		force := fs.Bool("force", false, "display binary files")
+		return func(args []string) error {
+			if len(args) != 1 {
+				return usageErrorf("expected the name of a file")
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				files, err := at(v)
+				if err != nil {
+					return err
+				}
+				if !isTerminal(os.Stdout) {
+					w := bufio.NewWriter(os.Stdout)
+					defer w.Flush()
+					return streamFile(files, args[0], w, !*force)
+				}
+				return runPager(func(w io.Writer) error {
+					return streamFile(files, args[0], w, !*force)
+				})
+			})
+		}
+	},
+}
+
+var editCmd = &command{
+	name:    "edit",
+	args:    "<name>",
+	summary: "edit a file in a vault with $EDITOR",
+	help: `Decrypts the named file to a private temporary file (on a memory-backed file
+system, if available) and opens it in the editor given by $VISUAL or $EDITOR.
+If the file was changed once the editor exits, it is encrypted and added to the
+vault as a new version of the file.
+The temporary file is overwritten and removed afterwards.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		return func(args []string) error {
+			if len(args) != 1 {
+				return usageErrorf("expected the name of a file")
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				changed, err := v.EditFile(args[0], runEditor)
+				if err != nil {
+					return err
+				}
+				if !changed {
+					fmt.Printf("'%s' was not changed\n", args[0])
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var rmCmd = &command{
+	name:    "rm",
+	args:    "<name>...",
+	summary: "remove files from a vault",
+	help: `Removes the named files (all of their versions) from the vault, deleting their
+ciphertexts (unless they are still referenced by a snapshot).
+A single version of a file can be removed using "<name>@<version>".
+If a name is a glob pattern, all the matching files are removed, after asking
+for confirmation.
+If the vault's trash is enabled (see "gringotts help trash"), the removed files
+are kept in the trash, from which they can be restored, for the configured
+number of days; otherwise they are not recoverable.
+With --dry-run, the versions which would be removed are listed, but nothing is
+changed; with --interactive, the removal of each version is confirmed.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		yes := yesFlag(fs)
+		dryRun, interactive := reviewFlags(fs)
+		return func(args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected files to remove")
+			}
+			return reviewVault(*vaultName, *dryRun, func(v *AESVault) error {
+				var entries []*AESVaultEntry
+				seen := make(map[*AESVaultEntry]bool)
+				for _, name := range args {
+					names := []string{name}
+					if v.resolveFile(name) == nil && isGlob(name) {
+						matches, err := matchEntries(v, name, "removed", *yes || *dryRun || *interactive)
+						if err != nil {
+							return err
+						}
+						names = nil
+						for _, entry := range matches {
+							names = append(names, entry.Filename)
+						}
+					}
+					for _, name := range names {
+						versions, err := v.FileEntries(name)
+						if err != nil {
+							return err
+						}
+						for _, entry := range versions {
+							if !seen[entry] {
+								seen[entry] = true
+								entries = append(entries, entry)
+							}
+						}
+					}
+				}
+				effects := v.RemovalEffects(entries)
+				switch {
+				case *dryRun:
+					fmt.Printf("Files which would be removed (dry run):\n")
+					for i, entry := range entries {
+						fmt.Printf("%s@%d: %s\n", entry.Filename, entry.Version, effects[i])
+					}
+					return nil
+				case *interactive:
+					entries, _ = confirmEntries("Remove", entries, effects)
+				}
+				return v.RemoveEntries(entries)
+			})
+		}
+	},
+}
+
+var mvCmd = &command{
+	name:    "mv",
+	args:    "<name> <new name>",
+	summary: "rename a file (or move a directory) in a vault",
+	help: `Renames a file (all of its versions) in the vault.
+If the name is a directory in the vault, all the files under it are moved to the
+new directory.
+The ciphertexts are not modified, and renaming fails if a file with the new
+name already exists. If removed versions of a file with the new name are in the
+trash, the renamed versions are numbered after them.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		return func(args []string) error {
+			if len(args) != 2 {
+				return usageErrorf("expected the name of the file and its new name")
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				return v.Rename(args[0], args[1])
+			})
+		}
+	},
+}
+
+var historyCmd = &command{
+	name:    "history",
+	args:    "<name>",
+	summary: "list the versions of a file",
+	help: `Lists the versions of the file, along with the time they were added and their
+sizes.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		at := snapshotFlag(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 1 {
+				return usageErrorf("expected the name of a file")
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				files, err := at(v)
+				if err != nil {
+					return err
+				}
+				versions, err := files.History(args[0])
+				if err != nil {
+					return err
+				}
+				return printEntries(format, versions, func(entry *AESVaultEntry) {
+					fmt.Printf("%s@%d %s %db\n", entry.Filename, entry.Version, formatTime(entry.Added), entry.FileSize())
+				})
+			})
+		}
+	},
+}
+
+var tagCmd = &command{
+	name:    "tag",
+	args:    "<name>",
+	summary: "annotate a file with tags, a note and custom metadata",
+	help: `Annotates the latest version of the file (or "<name>@<version>") with tags, a
+note and custom key/value metadata, which are stored (encrypted) in the vault.
+If no annotations are given, the file's annotations are displayed.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		annotation := annotationFlags(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 1 {
+				return usageErrorf("expected the name of a file")
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			a := annotation()
+			return withVault(*vaultName, func(v *AESVault) error {
+				if !a.empty() {
+					return v.Annotate(args[0], a)
+				}
+				entry := v.resolveFile(args[0])
+				if entry == nil {
+					return fmt.Errorf("no entry for '%s' in vault", args[0])
+				}
+				if format != formatText {
+					return printNDJSON(newEntryJSON(entry))
+				}
+				fmt.Printf("tags: %s\n", strings.Join(entry.Tags, ","))
+				fmt.Printf("note: %s\n", entry.Note)
+				fmt.Printf("meta: %s\n", keyValueFlag(entry.Meta).String())
+				return nil
+			})
+		}
+	},
+}
+
+var searchCmd = &command{
+	name:    "search",
+	args:    "<term>...",
+	summary: "search the contents of files using the full-text index",
+	help: `Lists the files containing all the search terms (case-insensitive), using the
+vault's full-text index (see "gringotts help set").
+A term ending in '*' matches any word starting with it, e.g. "invoic*".`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected search terms")
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				entries, err := v.Search(strings.Join(args, " "))
+				if err != nil {
+					return err
+				}
+				return printEntries(format, entries, func(entry *AESVaultEntry) {
+					fmt.Printf("%s %db\n", entry.Name(), entry.FileSize())
+				})
+			})
+		}
+	},
+}
+
+var snapshotCmd = &command{
+	name:    "snapshot",
+	args:    "create|list|delete|rollback [label]",
+	summary: "manage snapshots of a vault",
+	help: `Manages snapshots of the vault.
+A snapshot is an immutable record of the files in the vault (and their
+versions) at the time it was created; unchanged files share their ciphertexts
+with it.
+
+  snapshot create <label>    creates a snapshot with the given label
+  snapshot list              lists the snapshots in the vault
+  snapshot delete <label>    deletes a snapshot
+  snapshot rollback <label>  restores the vault to the state recorded in the
+                             snapshot, removing the files added since
+
+The files in a snapshot can be listed and decrypted using the --at option of
+the ls, get and history commands.
+The file versions which only the deleted snapshot refers to, and those removed
+by a rollback, are moved to the trash if it is enabled (see "gringotts help
+trash"), and are otherwise not recoverable. They are listed, and the action
+confirmed, before the snapshot is deleted or the vault rolled back; with
+--dry-run, they are listed but nothing is changed.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		yes := yesFlag(fs)
+		dryRun := fs.Bool("dry-run", false, "show what would be removed, without removing anything")
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) == 0 {
+				return usageErrorf("expected a snapshot action")
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			action := args[0]
+			if action == "list" && len(args) != 1 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args[1:], " "))
+			}
+			var run func(v *AESVault, label string) error
+			switch action {
+			case "create":
+				run = (*AESVault).CreateSnapshot
+			case "delete":
+				run = (*AESVault).DeleteSnapshot
+			case "rollback":
+				run = (*AESVault).Rollback
+			case "list":
+				run = func(v *AESVault, label string) error {
+					snapshots := newSnapshotsJSON(v.Snapshots)
+					items := make([]interface{}, len(snapshots))
+					for i := range snapshots {
+						items[i] = snapshots[i]
+					}
+					return printList(format, map[string]interface{}{"snapshots": snapshots}, items, func() {
+						for _, snap := range v.Snapshots {
+							fmt.Printf("%s %s %d files\n", snap.Label, formatTime(snap.Created), len(snap.Files))
+						}
+					})
+				}
+			default:
+				return usageErrorf("unknown snapshot action '%s'", action)
+			}
+			var label string
+			if action != "list" {
+				if len(args) != 2 {
+					return usageErrorf("expected a snapshot label")
+				}
+				label = args[1]
+			}
+			review := action == "delete" || action == "rollback"
+			if *dryRun && !review {
+				return usageErrorf("--dry-run only applies to the delete and rollback actions")
+			}
+			return reviewVault(*vaultName, *dryRun, func(v *AESVault) error {
+				if !review {
+					return run(v, label)
+				}
+				var entries []*AESVaultEntry
+				var effects []string
+				if action == "delete" {
+					entries, err = v.SnapshotRemovals(label)
+					effects = v.SnapshotRemovalEffects(entries)
+				} else {
+					entries, err = v.RollbackRemovals(label)
+					effects = v.RemovalEffects(entries)
+				}
+				if err != nil {
+					return err
+				}
+				switch {
+				case *dryRun:
+					fmt.Printf("Files which would be removed (dry run):\n")
+					for i, entry := range entries {
+						fmt.Printf("%s@%d: %s\n", entry.Filename, entry.Version, effects[i])
+					}
+					return nil
+				case len(entries) != 0 && !*yes:
+					fmt.Fprintf(os.Stderr, "The following files will be removed:\n")
+					for i, entry := range entries {
+						fmt.Fprintf(os.Stderr, "%s@%d: %s\n", entry.Filename, entry.Version, effects[i])
+					}
+					if !confirm("Continue?") {
+						return nil
+					}
+				}
+				return run(v, label)
+			})
+		}
+	},
+}
+
+var trashCmd = &command{
+	name:    "trash",
+	args:    "list|restore|empty [name...]",
+	summary: "manage the removed files of a vault",
+	help: `Manages the vault's trash.
+Files removed from the vault (by the rm and prune commands, or by the retention
+setting) are moved to the trash, and the ciphertexts removed by the gc command
+are kept in it, for the number of days given by the trash setting (see
+"gringotts help set"). The items in the trash are deleted once they expire.
+
+  trash list                  lists the items in the trash, and when they expire
+  trash restore <name>...     restores the removed versions of the files, or
+                              single versions using "<name>@<version>"
+  trash empty                 deletes all the items in the trash now
+
+With --dry-run, "trash empty" lists the items which would be deleted, but
+nothing is changed.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		dryRun := fs.Bool("dry-run", false, "show what would be deleted, without deleting anything")
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) == 0 {
+				args = []string{"list"}
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			action := args[0]
+			switch action {
+			case "list", "empty":
+				if len(args) != 1 {
+					return usageErrorf("unexpected arguments: %s", strings.Join(args[1:], " "))
+				}
+			case "restore":
+				if len(args) == 1 {
+					return usageErrorf("expected files to restore")
+				}
+			default:
+				return usageErrorf("unknown trash action '%s'", action)
+			}
+			return reviewVault(*vaultName, *dryRun, func(v *AESVault) error {
+				items := v.Trash
+				switch action {
+				case "restore":
+					for _, name := range args[1:] {
+						if _, err := v.RestoreTrash(name); err != nil {
+							return err
+						}
+					}
+					return nil
+				case "empty":
+					if !*dryRun {
+						if _, err := v.EmptyTrash(); err != nil {
+							return err
+						}
+					}
+				}
+				list := make([]trashItemJSON, len(items))
+				listItems := make([]interface{}, len(items))
+				for i, item := range items {
+					list[i] = newTrashItemJSON(item, v.TrashDays)
+					listItems[i] = list[i]
+				}
+				return printList(format, map[string]interface{}{"trash": list}, listItems, func() {
+					if action == "empty" && len(items) != 0 {
+						if *dryRun {
+							fmt.Printf("Items which would be deleted (dry run):\n")
+						} else {
+							fmt.Printf("Deleted items:\n")
+						}
+					}
+					for _, item := range items {
+						fmt.Printf("%s %s (expires %s)\n", formatTime(item.Deleted), item.Name(), formatTime(item.Expires(v.TrashDays)))
+					}
+				})
+			})
+		}
+	},
+}
+
+var setCmd = &command{
+	name:    "set",
+	args:    "<setting> <value>",
+	summary: "change the settings of a vault",
+	help: `Changes a setting of the vault.
+The available settings are:
+
+  retention <count>
+    The number of versions of each file that the vault retains.
+    Older versions exceeding this number are deleted, now and whenever a new
+    version is added.
+    A count of 0 retains all versions (the default).
+
+  fulltext on|off
+    Enables or disables the vault's full-text index, which is used by the
+    search command.
+    When enabled, the text of files added to the vault (plain text, markdown,
+    source code and, where feasible, PDF documents up to 16MB) is indexed.
+    Enabling the index also indexes the files already in the vault.
+    The index is stored in the (encrypted) vault file.
+
+  trash <days>|off
+    The number of days for which removed files are kept in the vault's trash
+    (see "gringotts help trash"), 30 for new vaults.
+    "off" (or 0) disables the trash, deleting the items in it; removed files
+    are then deleted immediately.
+
+  parity <percent>|off
+    The amount of parity data computed for each ciphertext, as a percentage of
+    its size (up to 100), which allows damaged ciphertexts to be repaired with
+    the repair command.
+    Each ciphertext is divided into chunks of 64KiB, in groups of 16 chunks;
+    each group can be repaired as long as the number of its damaged chunks (in
+    the ciphertext or its parity data) is at most 16 * percent / 100 (rounded
+    up). For example, 25 (4 parity chunks per group) repairs up to 4 damaged
+    chunks in each group.
+    Changing the setting computes the parity data of the files already in the
+    vault (which must pass the integrity test); "off" deletes it.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		return func(args []string) error {
+			if len(args) != 2 {
+				return usageErrorf("expected a setting and its value")
+			}
+			value := args[1]
+			switch args[0] {
+			case "retention":
+				count, err := strconv.Atoi(value)
+				if err != nil || count < 0 {
+					return usageErrorf("invalid retention count '%s'", value)
+				}
+				return withVault(*vaultName, func(v *AESVault) error {
+					pruned, err := v.SetMaxVersions(count)
+					if len(pruned) != 0 {
+						fmt.Printf("Pruned versions:\n")
+						for _, f := range pruned {
+							fmt.Printf("%s\n", f)
+						}
+					}
+					return err
+				})
+			case "fulltext":
+				if value != "on" && value != "off" {
+					return usageErrorf("expected 'on' or 'off', got '%s'", value)
+				}
+				return withVault(*vaultName, func(v *AESVault) error {
+					return v.SetFullText(value == "on")
+				})
+			case "trash":
+				days, err := strconv.Atoi(value)
+				if value == "off" {
+					days, err = 0, nil
+				}
+				if err != nil || days < 0 {
+					return usageErrorf("invalid number of days '%s'", value)
+				}
+				return withVault(*vaultName, func(v *AESVault) error {
+					return v.SetTrashDays(days)
+				})
+			case "parity":
+				overhead, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+				if value == "off" {
+					overhead, err = 0, nil
+				}
+				if err != nil || overhead < 0 || overhead > 100 {
+					return usageErrorf("invalid parity percentage '%s'", value)
+				}
+				return withVault(*vaultName, func(v *AESVault) error {
+					failed, err := v.SetParity(overhead)
+					if len(failed) != 0 {
+						fmt.Fprintf(os.Stderr, "No parity data was computed for:\n")
+						for _, f := range failed {
+							fmt.Fprintf(os.Stderr, "%s\n", f)
+						}
+					}
+					return err
+				})
+			}
+			return usageErrorf("unknown setting '%s'", args[0])
+		}
+	},
+}
+
+var infoCmd = &command{
+	name:    "info",
+	summary: "display information about a vault",
+	help: `Displays information about the vault: its encryption, the number of files
+(and versions of files) in it, the total size of the files (latest versions),
+its settings and its snapshots.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				name, _, _ := resolveVault(*vaultName)
+				info := newInfoJSON(name, v)
+				switch format {
+				case formatJSON:
+					return printJSON(info)
+				case formatNDJSON:
+					return printNDJSON(info)
+				}
+				fmt.Printf("vault: %s\n", info.Vault)
+				fmt.Printf("path: %s\n", info.Path)
+				fmt.Printf("encryption: %s (key derivation: %s)\n", info.Encryption, info.KDF)
+				fmt.Printf("files: %d (%d versions)\n", info.Files, info.Versions)
+				fmt.Printf("size: %db\n", info.Size)
+				if info.MaxVersions == 0 {
+					fmt.Printf("retention: all versions\n")
+				} else {
+					fmt.Printf("retention: %d versions\n", info.MaxVersions)
+				}
+				fmt.Printf("fulltext: %t\n", info.FullText)
+				if info.TrashDays == 0 {
+					fmt.Printf("trash: off\n")
+				} else {
+					fmt.Printf("trash: %d items (kept for %d days)\n", info.Trash, info.TrashDays)
+				}
+				if info.Parity == 0 {
+					fmt.Printf("parity: off\n")
+				} else {
+					fmt.Printf("parity: %d%%\n", info.Parity)
+				}
+				fmt.Printf("snapshots: %d\n", len(info.Snapshots))
+				return nil
+			})
+		}
+	},
+}
+
+var shellCmd = &command{
+	name:    "shell",
+	summary: "run an interactive shell on an unlocked vault",
+	help: `Unlocks the vault once and runs commands on it interactively, without
+prompting for the password for every command.
+Type "help" in the shell for the list of its commands. Names are relative to
+the current directory in the vault (changed with "cd"); tab completes commands,
+names in the vault and (for "put") local paths, and the arrow keys recall
+previous commands.
+
+The vault is saved after every command. After the given time of inactivity,
+the vault is locked: its key is discarded and the password is asked for again
+before the next command is run.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		lockAfter := fs.Duration("lock-after", 5*time.Minute, "lock the vault after this much inactivity (0 disables locking)")
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			return runShell(*vaultName, *lockAfter)
+		}
+	},
+}
+
+var agentCmd = &command{
+	name:    "agent",
+	summary: "run an agent which holds the keys of unlocked vaults",
+	help: `Runs an agent, until it is interrupted, which holds the keys of unlocked
+vaults in memory so that commands do not prompt for the password of a vault
+every time.
+While the agent is running, the key of a vault is stored in it the first time
+the vault's password is entered, and later commands operating on the vault use
+it instead of prompting for the password.
+The agent discards all keys when it has not been used for the given time, or
+when "gringotts lock" is run.
+
+The agent listens on the Unix socket $GRINGOTTS_AGENT_SOCK, or
+$XDG_RUNTIME_DIR/gringotts/agent.sock (or a directory in /tmp if
+$XDG_RUNTIME_DIR is not set), which is only accessible by the user.
+On Linux, it also checks that connections come from the user's processes, and
+keeps the keys in locked memory, so that they are never swapped to disk.
+Note that the agent hands the keys of unlocked vaults to any process running as
+the user which connects to it (as the commands do), so while a vault is
+unlocked, its key is only as safe as the user's account; the protection of the
+agent's memory against debuggers only keeps other users out.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		timeout := fs.Duration("timeout", 15*time.Minute, "discard the keys after this much inactivity (0 disables the timeout)")
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			return runAgent(agentSocket(), *timeout)
+		}
+	},
+}
+
+var lockCmd = &command{
+	name:    "lock",
+	summary: "discard the keys held by the agent",
+	help: `Makes the agent discard the key of the given vault, or of all vaults, so that
+their passwords are asked for again.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := fs.String("vault", "", "name (or path) of the vault to lock; all vaults are locked if not given")
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			req := agentRequest{Op: agentLock}
+			if *vaultName != "" {
+				_, dir, err := resolveVault(*vaultName)
+				if err != nil {
+					return err
+				}
+				req.Vault = agentVaultID(dir)
+			}
+			_, err := callAgent(req)
+			return err
+		}
+	},
+}
+
+var browseCmd = &command{
+	name:    "browse",
+	summary: "browse a vault in a full-screen terminal UI",
+	help: `Displays the files in the vault as a tree, along with the metadata of the
+selected file, in a full-screen terminal UI.
+Files can be added (using a file picker), extracted, deleted and renamed, and
+the integrity of the vault can be checked; the keys are displayed at the
+bottom of the screen.
+The vault is saved after every change.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				name, _, _ := resolveVault(*vaultName)
+				return runBrowser(name, v)
+			})
+		}
+	},
+}
+
+var verifyCmd = &command{
+	name:    "verify",
+	summary: "check the ciphertexts in a vault for tampering",
+	help: `Checks if any ciphertexts have been tampered with, by recomputing their HMAC
+tags and comparing them with the ones recorded when the files were added.
+With --deep, each file is also decrypted (in memory) to check that its padding
+and size match its entry, and that the plaintext matches the checksum recorded
+when the file was added (files added by earlier versions have no checksum).
+This catches entries whose metadata (such as the IV) is corrupt, which the HMAC
+tag does not cover.
+For damaged ciphertexts which have parity data (see "gringotts help set"), the
+results tell whether the damage can be repaired with the repair command.
+Several ciphertexts are checked concurrently (see --jobs).
+The results are listed in the order of the files in the vault, except with
+--format=ndjson, where each result is written as soon as it is available.
+The command fails (exit code 1) if any test fails, or if it is interrupted.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		deep := fs.Bool("deep", false, "also decrypt the files and check their size, padding and checksum")
+		jobs := jobsFlag(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			n, err := jobs()
+			if err != nil {
+				return err
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				ctx, stop := interruptContext()
+				defer stop()
+				opts := IntegrityOptions{Concurrency: n, Deep: *deep}
+				if format == formatNDJSON {
+					opts.OnResult = func(r EntryIntegrity, done, total int) {
+						printNDJSON(newTestResultJSON(r))
+					}
+				}
+				results, verifyErr := v.Verify(ctx, opts)
+				v.recordVerification(results, time.Now())
+				report := newIntegrityJSON(results)
+				switch format {
+				case formatJSON:
+					if err := printJSON(report); err != nil {
+						return err
+					}
+				case formatText:
+					printIntegrity(report)
+				}
+				if verifyErr != nil {
+					return fmt.Errorf("integrity test interrupted (%d of %d files tested)", report.Total, len(v.Files))
+				}
+				if report.Failed != 0 {
+					return fmt.Errorf("%d tests failed", report.Failed)
+				}
+				return nil
+			})
+		}
+	},
+}
+
+// printIntegrity displays the results of an integrity test of the vault.
+func printIntegrity(report integrityJSON) {
+	fmt.Printf("Summary:\n")
+	fmt.Printf("%d/%d tests passed\n", report.Passed, report.Total)
+	fmt.Printf("%d/%d tests failed\n", report.Failed, report.Total)
+	fmt.Printf("%d/%d tests inconclusive\n", report.Inconclusive, report.Total)
+	if report.Repairable != 0 || report.Unrecoverable != 0 {
+		fmt.Printf("%d damaged ciphertexts repairable (see 'gringotts repair'), %d unrecoverable\n", report.Repairable, report.Unrecoverable)
+	}
+	// print detailed results
+	printCategory := func(cat, status string) {
+		var matching []testResultJSON
+		for _, r := range report.Results {
+			if r.Status == status {
+				matching = append(matching, r)
+			}
+		}
+		if len(matching) == 0 {
+			return
+		}
+		fmt.Printf("%s ciphertexts (correspond to):\n", cat)
+		for _, r := range matching {
+			if r.Reason != "" {
+				fmt.Printf("%s@%d: %s\n", r.Name, r.Version, r.Reason)
+			} else {
+				fmt.Printf("%s@%d\n", r.Name, r.Version)
+			}
+		}
+	}
+	fmt.Printf("\nDetailed Results:\n")
+	printCategory("Passed", statusPassed)
+	printCategory("Failed", statusFailed)
+	printCategory("Inconclusive", statusInconclusive)
+}
+
+var scrubCmd = &command{
+	name:    "scrub",
+	summary: "check the integrity of part of a vault",
+	help: `Checks the integrity of the ciphertexts in the vault (as the verify command
+does) which have gone the longest without being checked, starting with the
+ones which were never checked, until the time or size limit is reached.
+Running it regularly (e.g. nightly, from cron) checks the whole vault over a
+number of runs, without reading all of it every time.
+The result of each check is recorded in the vault, for the health command.
+With --auto-repair, damaged ciphertexts which are repairable with their parity
+data (see "gringotts help repair") are repaired.
+The command fails (exit code 1) if any check fails (and the ciphertext is not
+repaired), or if it is interrupted.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		maxTime := fs.Duration("max-time", 30*time.Minute, "stop checking files after this much time (0 for no limit)")
+		maxBytes := fs.String("max-bytes", "", "total size of the files to check, e.g. 10G (no limit by default)")
+		deep := fs.Bool("deep", false, "also decrypt the files and check their size, padding and checksum")
+		autoRepair := fs.Bool("auto-repair", false, "repair damaged files which have parity data")
+		jobs := jobsFlag(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			n, err := jobs()
+			if err != nil {
+				return err
+			}
+			limit, err := parseSize(*maxBytes)
+			if err != nil {
+				return err
+			}
+			if *maxTime < 0 {
+				return usageErrorf("invalid time limit %s", *maxTime)
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				ctx, stop := interruptContext()
+				defer stop()
+				opts := ScrubOptions{MaxTime: *maxTime, MaxBytes: limit, Concurrency: n, Deep: *deep, Repair: *autoRepair}
+				if format == formatNDJSON {
+					opts.OnResult = func(r EntryIntegrity, done, total int) {
+						printNDJSON(newTestResultJSON(r))
+					}
+				}
+				scrub, scrubErr := v.Scrub(ctx, opts)
+				report := newScrubJSON(scrub)
+				switch format {
+				case formatJSON:
+					if err := printJSON(report); err != nil {
+						return err
+					}
+				case formatText:
+					fmt.Printf("Checked %d files (%db), %d remaining\n", report.Total, report.Bytes, report.Remaining)
+					printIntegrity(report.integrityJSON)
+					for _, r := range report.Repairs {
+						if r.Status == "error" {
+							fmt.Printf("%s@%d: repair error: %s\n", r.Name, r.Version, r.Error)
+						} else {
+							fmt.Printf("%s@%d: %s (%s)\n", r.Name, r.Version, r.Status, describeDamage(r.Damaged, r.WrongSize))
+						}
+					}
+				}
+				if scrubErr != nil {
+					return fmt.Errorf("scrub interrupted (%d files checked)", report.Total)
+				}
+				failed := report.Failed
+				for _, r := range report.Repairs {
+					if r.Status == "repaired" {
+						failed--
+					}
+				}
+				if failed > 0 {
+					return fmt.Errorf("%d tests failed", failed)
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var healthCmd = &command{
+	name:    "health",
+	summary: "report on the integrity checks of a vault",
+	help: `Reports on the recorded integrity checks of the files in the vault (by the
+verify, scrub and repair commands): the files which were never checked, those
+which were not checked within the given number of days (counting from when
+they were added, if they were never checked), those which failed their last
+check and those which were repaired.
+The command fails (exit code 1) if any file failed its last check or was not
+checked within the given number of days, so that it can be used to monitor the
+vault (e.g. from cron, after the scrub command).`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		staleDays := fs.Int("stale-days", 30, "number of days after which a check is out of date")
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			if *staleDays < 1 {
+				return usageErrorf("invalid number of days %d", *staleDays)
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				health := v.Health(time.Duration(*staleDays)*24*time.Hour, time.Now())
+				report := newHealthJSON(health)
+				switch format {
+				case formatJSON:
+					if err := printJSON(report); err != nil {
+						return err
+					}
+				case formatNDJSON:
+					if err := printNDJSON(report); err != nil {
+						return err
+					}
+				default:
+					fmt.Printf("files: %d\n", report.Files)
+					fmt.Printf("never checked: %d\n", len(report.NeverVerified))
+					fmt.Printf("not checked in %d days: %d\n", report.StaleDays, len(report.Stale))
+					fmt.Printf("failed: %d\n", len(report.Failed))
+					fmt.Printf("repaired: %d\n", len(report.Repaired))
+					printEntries := func(title string, entries []healthEntryJSON) {
+						if len(entries) == 0 {
+							return
+						}
+						fmt.Printf("\n%s:\n", title)
+						for _, e := range entries {
+							switch {
+							case e.Verified == nil:
+								var added time.Time
+								if e.Added != nil {
+									added = *e.Added
+								}
+								fmt.Printf("%s@%d (added %s, never checked)\n", e.Name, e.Version, formatTime(added))
+							case e.Repaired != nil:
+								fmt.Printf("%s@%d (repaired %s)\n", e.Name, e.Version, formatTime(*e.Repaired))
+							case e.Reason != "":
+								fmt.Printf("%s@%d (checked %s): %s: %s\n", e.Name, e.Version, formatTime(*e.Verified), e.Status, e.Reason)
+							default:
+								fmt.Printf("%s@%d (checked %s)\n", e.Name, e.Version, formatTime(*e.Verified))
+							}
+						}
+					}
+					printEntries("Failed", report.Failed)
+					printEntries("Not checked recently", report.Stale)
+					printEntries("Repaired", report.Repaired)
+				}
+				if !report.Healthy {
+					return fmt.Errorf("%d files failed their last check, %d were not checked in %d days", len(report.Failed), len(report.Stale), report.StaleDays)
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var repairCmd = &command{
+	name:    "repair",
+	summary: "repair damaged ciphertexts using their parity data",
+	help: `Checks the ciphertexts which have parity data (see "gringotts help set")
+against it, and reconstructs their damaged chunks (or those of their parity
+data) where possible.
+A repaired ciphertext only replaces the damaged one if it matches the HMAC tag
+recorded when the file was added. Ciphertexts without parity data are not
+checked (use the verify command).
+Several ciphertexts are checked concurrently (see --jobs).
+The command fails (exit code 1) if any ciphertext could not be repaired, or if
+it is interrupted.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		jobs := jobsFlag(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			n, err := jobs()
+			if err != nil {
+				return err
+			}
+			return withVault(*vaultName, func(v *AESVault) error {
+				ctx, stop := interruptContext()
+				defer stop()
+				results, repairErr := v.Repair(ctx, n)
+				v.recordRepairs(results, time.Now())
+				report := newRepairJSON(results)
+				items := make([]interface{}, len(report.Results))
+				for i, r := range report.Results {
+					items[i] = r
+				}
+				err := printList(format, report, items, func() {
+					for _, r := range report.Results {
+						switch r.Status {
+						case "intact":
+						case "error":
+							fmt.Printf("%s@%d: error: %s\n", r.Name, r.Version, r.Error)
+						default:
+							fmt.Printf("%s@%d: %s (%s)\n", r.Name, r.Version, r.Status, describeDamage(r.Damaged, r.WrongSize))
+						}
+					}
+					fmt.Printf("%d ciphertexts checked: %d repaired, %d unrecoverable\n", report.Checked, report.Repaired, report.Unrecoverable)
+				})
+				if err != nil {
+					return err
+				}
+				if repairErr != nil {
+					return fmt.Errorf("repair interrupted (%d ciphertexts checked)", report.Checked)
+				}
+				if failed := report.Unrecoverable + report.Errors; failed != 0 {
+					return fmt.Errorf("%d ciphertexts could not be repaired", failed)
+				}
+				return nil
+			})
+		}
+	},
+}
+
+var gcCmd = &command{
+	name:    "gc",
+	summary: "delete unlinked ciphertexts from a vault",
+	help: `Performs a cleanup of the vault directory.
+This involves removing all ciphertext files which do not correspond to a file
+entry in the vault or any of its snapshots or its trash (since they have no
+chance of being decrypted anymore).
+If the vault's trash is enabled (see "gringotts help trash"), the files are
+moved to the trash rather than deleted.
+With --dry-run, the files which would be removed are listed, but nothing is
+changed; with --interactive, each removal is confirmed.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		dryRun, interactive := reviewFlags(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			return reviewVault(*vaultName, *dryRun, func(v *AESVault) error {
+				unlinked, err := v.UnlinkedCiphertexts()
+				if err != nil {
+					return err
+				}
+				effect := "deleted"
+				if v.TrashDays > 0 {
+					effect = fmt.Sprintf("moved to the trash for %d days", v.TrashDays)
+				}
+				if *interactive && !*dryRun {
+					effects := make([]string, len(unlinked))
+					for i := range effects {
+						effects[i] = effect
+					}
+					var confirmed []string
+					for _, i := range confirmEach("Remove", unlinked, effects) {
+						confirmed = append(confirmed, unlinked[i])
+					}
+					unlinked = confirmed
+				}
+				deleted := []string{}
+				for _, name := range unlinked {
+					deleted = append(deleted, filepath.Join(v.dirName, name))
+				}
+				if !*dryRun {
+					v.DeleteCiphertexts(unlinked)
+				}
+				items := make([]interface{}, len(deleted))
+				for i, f := range deleted {
+					items[i] = map[string]string{"ciphertext": f}
+				}
+				doc := cleanupJSON{Deleted: deleted, DryRun: *dryRun, Trash: v.TrashDays > 0}
+				return printList(format, doc, items, func() {
+					if len(deleted) == 0 {
+						return
+					}
+					if *dryRun {
+						fmt.Printf("Ciphertexts which would be %s (dry run):\n", effect)
+					} else if v.TrashDays > 0 {
+						fmt.Printf("Ciphertexts %s:\n", effect)
+					} else {
+						fmt.Printf("Deleted ciphertexts:\n")
+					}
+					for _, f := range deleted {
+						fmt.Printf("%s\n", f)
+					}
+				})
+			})
+		}
+	},
+}
+
+var pruneCmd = &command{
+	name:    "prune",
+	summary: "remove file entries without ciphertexts from a vault",
+	help: `Performs a cleanup of the vault's internal data.
+This involves removing all file entries which do not have corresponding
+ciphertext files in the vault.
+If the vault's trash is enabled (see "gringotts help trash"), the entries are
+moved to the trash rather than deleted.
+With --dry-run, the entries which would be removed are listed, but nothing is
+changed; with --interactive, each removal is confirmed.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		dryRun, interactive := reviewFlags(fs)
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			return reviewVault(*vaultName, *dryRun, func(v *AESVault) error {
+				pruned := v.MissingCiphertexts()
+				effects := v.RemovalEffects(pruned)
+				if *interactive && !*dryRun {
+					pruned, effects = confirmEntries("Remove", pruned, effects)
+				}
+				if !*dryRun {
+					if err := v.RemoveEntries(pruned); err != nil {
+						return err
+					}
+				}
+				entries := newEntriesJSON(pruned)
+				items := make([]interface{}, len(entries))
+				for i := range entries {
+					items[i] = entries[i]
+				}
+				doc := pruneJSON{Pruned: entries, DryRun: *dryRun, Trash: v.TrashDays > 0}
+				return printList(format, doc, items, func() {
+					if len(pruned) == 0 {
+						return
+					}
+					if *dryRun {
+						fmt.Printf("Entries which would be pruned (dry run):\n")
+					} else {
+						fmt.Printf("Pruned entries:\n")
+					}
+					for i, entry := range pruned {
+						fmt.Printf("%s@%d: %s\n", entry.Filename, entry.Version, effects[i])
+					}
+				})
+			})
+		}
+	},
+}
+
+var fsckCmd = &command{
+	name:    "fsck",
+	args:    "[--fix classes]",
+	summary: "check a vault's file against its directory",
+	help: `Cross-checks the vault file against the vault directory, reporting each
+problem found along with its class:
+    unexpected: files (or subdirectories) in the vault directory which are
+        neither ciphertexts nor vault files
+    duplicate-name: several entries for the same version of a file, or
+        entries with invalid version numbers
+    duplicate-ciphertext: entries for different files (or versions) sharing
+        a ciphertext
+    size: entries whose size and padding do not match their ciphertext
+    missing: entries whose ciphertext (or parity file) is missing
+    orphan: ciphertexts which do not correspond to any entry
+    index: a full-text index referring to deleted ciphertexts, or a vault file
+        older than the last one saved (e.g. restored from a backup), as
+        recorded in the params file
+Without --fix, the vault is not changed.
+With --fix, the problems of the given classes (a comma-separated list, or
+"all") are fixed where this can be done safely:
+    unexpected files are moved to a "<vault>.lost+found" directory next to the
+        vault directory (files left by interrupted commands are deleted)
+    duplicate entries for the same ciphertext are merged, and the other
+        versions of the file are renumbered
+    entries sharing a ciphertext are given their own copy of it
+    sizes are only corrected if the ciphertext is intact and decrypts to the
+        entry's checksum with the corrected size
+    entries without ciphertexts are removed, and missing parity files are
+        recomputed
+    orphan ciphertexts are moved to the trash (or to the lost+found
+        directory, if the trash is disabled or the vault file is an older
+        copy)
+    the full-text index is pruned, and an older vault file is accepted as
+        the current one
+The command fails (exit code 1) if problems remain.`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		vaultName := vaultFlag(fs)
+		fix := fs.String("fix", "", "comma-separated classes of problems to fix, or \"all\"")
+		outputFormat := formatFlag(fs)
+		return func(args []string) error {
+			if len(args) != 0 {
+				return usageErrorf("unexpected arguments: %s", strings.Join(args, " "))
+			}
+			format, err := outputFormat()
+			if err != nil {
+				return err
+			}
+			known := make(map[string]bool)
+			for _, class := range fsckClasses {
+				known[class] = true
+			}
+			classes := make(map[string]bool)
+			for _, class := range strings.Split(*fix, ",") {
+				switch {
+				case class == "":
+				case class == "all":
+					classes = known
+				case known[class]:
+					classes[class] = true
+				default:
+					return usageErrorf("unknown class of problems '%s' (expected one of %s, or all)", class, strings.Join(fsckClasses, ", "))
+				}
+			}
+			// the vault is only saved if problems are fixed, so that checking
+			// it does not change the vault file
+			v, err := openVault(*vaultName)
+			if err != nil {
+				return err
+			}
+			problems, err := v.Fsck()
+			if err != nil {
+				return err
+			}
+			v.FsckFix(problems, classes)
+			report := fsckJSON{Problems: make([]fsckProblemJSON, len(problems))}
+			items := make([]interface{}, len(problems))
+			for i, p := range problems {
+				report.Problems[i] = newFsckProblemJSON(p)
+				items[i] = report.Problems[i]
+				if p.Fixed {
+					report.Fixed++
+				} else {
+					report.Remaining++
+				}
+			}
+			if report.Fixed != 0 {
+				if err := v.Close(); err != nil {
+					return fmt.Errorf("vault save error: %s", err.Error())
+				}
+			}
+			err = printList(format, report, items, func() {
+				for _, p := range problems {
+					status := ""
+					switch {
+					case p.Fixed:
+						status = " [fixed]"
+					case p.FixErr != nil:
+						status = fmt.Sprintf(" [fix failed: %s]", p.FixErr.Error())
+					case !p.Fixable():
+						status = " [not fixable]"
+					}
+					fmt.Printf("%s: %s: %s%s\n", p.Class, p.Name, p.Detail, status)
+				}
+				if len(problems) == 0 {
+					fmt.Printf("no problems found\n")
+				} else {
+					fmt.Printf("%d problems, %d fixed\n", len(problems), report.Fixed)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if report.Remaining != 0 {
+				return fmt.Errorf("%d problems remain", report.Remaining)
+			}
+			return nil
+		}
+	},
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completeFiles is output by the __complete command in place of candidates
+// when the word being completed is a path on the local file system, which the
+// shell completes itself.
+const completeFiles = ":files"
+
+// Positional arguments of the commands, other than entry names.
+var (
+	snapshotActions  = []string{"create", "list", "delete", "rollback"}
+	trashActions     = []string{"list", "restore", "empty"}
+	vaultActions     = []string{"list", "add", "remove", "default"}
+	settings         = []string{"retention", "fulltext", "trash", "parity"}
+	completionShells = []string{"bash", "zsh", "fish"}
+)
+
+// completionScripts are the completion scripts of the supported shells.
+var completionScripts = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// entryCommands are the commands whose positional arguments are entry names.
+var entryCommands = map[string]bool{
+	"get": true, "cat": true, "view": true, "edit": true, "rm": true,
+	"mv": true, "history": true, "tag": true,
+}
+
+// flagValues lists the values of the flags which take one of a fixed set of
+// values.
+var flagValues = map[string][]string{
+	"format": {formatText, formatJSON, formatNDJSON},
+	"cipher": {"aes-256", "aes-192", "aes-128"},
+	"kdf":    {kdfScrypt, kdfSHA256},
+	"sort":   {"name", "size", "date"},
+	"fix":    append([]string{"all"}, fsckClasses...),
+}
+
+// flagPaths are the flags whose values are local paths.
+var flagPaths = map[string]bool{"output": true}
+
+var completionCmd = &command{
+	name:    "completion",
+	args:    "bash|zsh|fish",
+	summary: "output a shell completion script",
+	help: `Outputs a script which completes the commands and options of gringotts, the
+names of the registered vaults and, while the vault is unlocked in the agent
+(see "gringotts help agent"), the names of the files in the vault.
+
+To enable it, add the following to the shell's startup file:
+  bash (~/.bashrc):                 source <(gringotts completion bash)
+  zsh (~/.zshrc, after compinit):   source <(gringotts completion zsh)
+  fish (~/.config/fish/config.fish): gringotts completion fish | source`,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			if len(args) != 1 {
+				return usageErrorf("expected a shell")
+			}
+			script, ok := completionScripts[args[0]]
+			if !ok {
+				return usageErrorf("unsupported shell '%s'", args[0])
+			}
+			_, err := os.Stdout.WriteString(script)
+			return err
+		}
+	},
+}
+
+// completeCmd is called by the completion scripts with the words of the
+// command line following "gringotts", up to the word being completed.
+var completeCmd = &command{
+	name:    "__complete",
+	args:    "[word...]",
+	summary: "list the completions of a command line",
+	hidden:  true,
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			for _, c := range complete(args) {
+				fmt.Println(c)
+			}
+			return nil
+		}
+	},
+}
+
+// complete returns the completions of the last of words, given the preceding
+// words of the command line.
+func complete(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	if len(words) == 1 {
+		var names []string
+		for _, cmd := range commands {
+			if !cmd.hidden {
+				names = append(names, cmd.name)
+			}
+		}
+		return filterPrefix(names, cur)
+	}
+	cmd := lookupCommand(words[0])
+	if cmd == nil || cmd.hidden {
+		return nil
+	}
+	fs, _ := newFlagSet(cmd)
+
+	// the preceding words, skipping the options
+	var positional []string
+	vault := ""
+	for i := 1; i < len(words)-1; i++ {
+		w := words[i]
+		if w == "--" {
+			positional = append(positional, words[i+1:len(words)-1]...)
+			break
+		}
+		name, value, hasValue := splitFlag(w)
+		f := fs.Lookup(name)
+		if name == "" || f == nil {
+			positional = append(positional, w)
+			continue
+		}
+		if !hasValue && !isBoolFlag(f) {
+			if i++; i == len(words)-1 {
+				// the word being completed is the value of the option
+				return completeFlagValue(name, cur)
+			}
+			value = words[i]
+		}
+		if name == "vault" {
+			vault = value
+		}
+	}
+
+	if name, value, hasValue := splitFlag(cur); name != "" || strings.HasPrefix(cur, "-") {
+		if hasValue {
+			candidates := completeFlagValue(name, value)
+			if len(candidates) == 1 && candidates[0] == completeFiles {
+				return candidates
+			}
+			for i := range candidates {
+				candidates[i] = "--" + name + "=" + candidates[i]
+			}
+			return candidates
+		}
+		var names []string
+		fs.VisitAll(func(f *flag.Flag) {
+			names = append(names, "--"+f.Name)
+		})
+		return filterPrefix(names, cur)
+	}
+	return completeArg(cmd.name, positional, vault, cur)
+}
+
+// completeArg returns the completions of a positional argument of the
+// command, following the positional arguments args.
+func completeArg(name string, args []string, vault, cur string) []string {
+	switch name {
+	case "help":
+		if len(args) == 0 {
+			return complete([]string{cur})
+		}
+	case "completion":
+		if len(args) == 0 {
+			return filterPrefix(completionShells, cur)
+		}
+	case "vault":
+		switch {
+		case len(args) == 0:
+			return filterPrefix(vaultActions, cur)
+		case len(args) == 1 && (args[0] == "remove" || args[0] == "default"):
+			return filterPrefix(registeredVaults(), cur)
+		case len(args) == 2 && args[0] == "add":
+			return []string{completeFiles}
+		}
+	case "snapshot":
+		if len(args) == 0 {
+			return filterPrefix(snapshotActions, cur)
+		}
+	case "trash":
+		if len(args) == 0 {
+			return filterPrefix(trashActions, cur)
+		}
+	case "set":
+		switch {
+		case len(args) == 0:
+			return filterPrefix(settings, cur)
+		case len(args) == 1 && args[0] == "fulltext":
+			return filterPrefix([]string{"on", "off"}, cur)
+		case len(args) == 1 && (args[0] == "parity" || args[0] == "trash"):
+			return filterPrefix([]string{"off"}, cur)
+		}
+	case "create", "add":
+		return []string{completeFiles}
+	}
+	if entryCommands[name] {
+		return completeEntryName(vault, cur)
+	}
+	return nil
+}
+
+// completeFlagValue returns the completions of the value of the flag.
+func completeFlagValue(name, cur string) []string {
+	if name == "vault" {
+		return filterPrefix(registeredVaults(), cur)
+	}
+	if flagPaths[name] {
+		return []string{completeFiles}
+	}
+	return filterPrefix(flagValues[name], cur)
+}
+
+// completeEntryName completes the name of an entry of the vault, up to the
+// next "/" so that directories are completed one level at a time.
+// Entry names are only completed if the vault is unlocked in the agent, since
+// completion must not prompt for the password.
+func completeEntryName(vault, cur string) []string {
+	names, err := agentEntries(vault)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var candidates []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, cur) {
+			continue
+		}
+		if i := strings.Index(name[len(cur):], "/"); i >= 0 {
+			name = name[:len(cur)+i+1]
+		}
+		if !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// splitFlag splits an option of the form "--name=value" or "--name" (with one
+// or two dashes). It returns an empty name if w is not an option.
+func splitFlag(w string) (name, value string, hasValue bool) {
+	if len(w) < 2 || w[0] != '-' || w == "--" {
+		return "", "", false
+	}
+	name = strings.TrimPrefix(strings.TrimPrefix(w, "-"), "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		return name[:i], name[i+1:], true
+	}
+	return name, "", false
+}
+
+// isBoolFlag returns whether the flag does not take a value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// filterPrefix returns the elements of list starting with prefix.
+func filterPrefix(list []string, prefix string) []string {
+	var matches []string
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) {
+			matches = append(matches, s)
+		}
+	}
+	return matches
+}
+
+// The completion scripts pass the words of the command line to
+// "gringotts __complete --", which outputs one candidate per line, or ":files"
+// to fall back to completing local paths.
+
+const bashCompletion = `# bash completion for gringotts
+_gringotts() {
+    local line="${COMP_LINE:0:COMP_POINT}"
+    local -a words
+    read -r -a words <<< "$line"
+    if [[ "$line" == *[[:space:]] ]]; then
+        words+=("")
+    fi
+    local cur="${words[${#words[@]}-1]}"
+    local IFS=$'\n'
+    COMPREPLY=($(gringotts __complete -- "${words[@]:1}" 2>/dev/null))
+    if [[ "${COMPREPLY[0]}" == ":files" ]]; then
+        COMPREPLY=($(compgen -f -- "${COMP_WORDS[COMP_CWORD]}"))
+        compopt -o filenames 2>/dev/null
+        return
+    fi
+    # bash splits "--option=value" at "=", so only the value is replaced
+    if [[ "$cur" == *=* ]]; then
+        COMPREPLY=("${COMPREPLY[@]#*=}")
+    fi
+    if [[ ${#COMPREPLY[@]} -eq 1 && "${COMPREPLY[0]}" == */ ]]; then
+        compopt -o nospace 2>/dev/null
+    fi
+}
+complete -F _gringotts gringotts
+`
+
+const zshCompletion = `#compdef gringotts
+# zsh completion for gringotts
+_gringotts() {
+    local -a candidates
+    local c
+    candidates=("${(@f)$(gringotts __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    if [[ "${candidates[1]}" == ":files" ]]; then
+        _files
+        return
+    fi
+    for c in "${candidates[@]}"; do
+        [[ -z "$c" ]] && continue
+        if [[ "$c" == */ ]]; then
+            compadd -Q -S '' -- "$c"
+        else
+            compadd -Q -- "$c"
+        fi
+    done
+}
+compdef _gringotts gringotts
+`
+
+const fishCompletion = `# fish completion for gringotts
+function __gringotts_complete
+    set -l words (commandline -opc) (commandline -ct)
+    set -l candidates (gringotts __complete -- $words[2..-1] 2>/dev/null)
+    if test "$candidates[1]" = ":files"
+        __fish_complete_path (commandline -ct)
+        return
+    end
+    printf '%s\n' $candidates
+end
+complete -c gringotts -f -a '(__gringotts_complete)'
+`
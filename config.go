@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The configuration file registers vaults under names, so that they can be
+// used from any directory, and holds the user's default settings.
+// It is stored in the user's configuration directory ($XDG_CONFIG_HOME or
+// ~/.config on Linux), or at the path given by $GRINGOTTS_CONFIG.
+
+// Config is the contents of the configuration file.
+type Config struct {
+	// DefaultVault is the vault used when --vault is not given
+	DefaultVault string `json:"default_vault,omitempty"`
+	// Cipher and KDF are the default encryption parameters of new vaults
+	Cipher string `json:"cipher,omitempty"`
+	KDF    string `json:"kdf,omitempty"`
+	// Format is the default output format of commands
+	Format string `json:"format,omitempty"`
+	// Vaults maps the names of registered vaults to their absolute paths
+	Vaults map[string]string `json:"vaults,omitempty"`
+}
+
+// config is the user's configuration, loaded by loadConfig.
+var config = Config{Cipher: "aes-256", KDF: kdfScrypt}
+
+// configPath returns the path of the configuration file.
+func configPath() (string, error) {
+	if p := os.Getenv("GRINGOTTS_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate configuration directory: %s", err.Error())
+	}
+	return filepath.Join(dir, "gringotts", "config.json"), nil
+}
+
+// loadConfig reads the configuration file, if it exists.
+func loadConfig() error {
+	p, err := configPath()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading config file: %s", err.Error())
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("malformed config file '%s': %s", p, err.Error())
+	}
+	return nil
+}
+
+// saveConfig writes the configuration file, creating its directory if needed.
+func saveConfig() error {
+	p, err := configPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return fmt.Errorf("error creating config directory: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(p, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("error writing config file: %s", err.Error())
+	}
+	return nil
+}
+
+// resolveVault returns the name (for display) and directory of the vault
+// referred to by name: a registered vault, or otherwise the path of the vault
+// directory.
+// If name is empty, the default vault is used.
+func resolveVault(name string) (string, string, error) {
+	if name == "" {
+		name = config.DefaultVault
+	}
+	if name == "" {
+		return "", "", usageErrorf("expected a vault to operate on (--vault)")
+	}
+	if dir, ok := config.Vaults[name]; ok {
+		return name, dir, nil
+	}
+	return name, name, nil
+}
+
+// registerVault registers the vault in the directory dir under name.
+func registerVault(name, dir string) error {
+	if name == "" || filepath.Base(name) != name {
+		return usageErrorf("invalid vault name '%s'", name)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s': %s", dir, err.Error())
+	}
+	if config.Vaults == nil {
+		config.Vaults = make(map[string]string)
+	}
+	config.Vaults[name] = abs
+	return saveConfig()
+}
+
+// registeredVaults returns the names of the registered vaults, in sorted order.
+func registeredVaults() []string {
+	var names []string
+	for name := range config.Vaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 )
 
-func (v *AESVault) encrypt(src, dst *os.File) (*AESVaultEntry, error) {
+var errAuthFailed = fmt.Errorf("ciphertext auth fail - possibility of tampering")
+
+// encrypt encrypts the contents of src into dst and returns the vault entry for
+// the ciphertext, named after name.
+func (v *AESVault) encrypt(name string, src, dst *os.File) (*AESVaultEntry, error) {
 	// stat the source file
 	stat, err := src.Stat()
 	if err != nil {
@@ -26,48 +31,41 @@ func (v *AESVault) encrypt(src, dst *os.File) (*AESVaultEntry, error) {
 	}
 	// prepare the file entry for the vault
 	fileEntry := &AESVaultEntry{
-		Filename:      stat.Name(),
+		Filename:      name,
 		Size:          stat.Size(),
 		IV:            iv,
-		EncryptedName: dst.Name(),
+		EncryptedName: filepath.Base(dst.Name()),
 		Padding:       paddingLen,
 	}
-	// write the encrypted version of the file to disk (dst)
-	srcBuff := make([]byte, enc.BlockSize())
-	dstBuff := make([]byte, enc.BlockSize())
-
-	// determine the number of blocks in the src file
-	blockCount := stat.Size() / int64(enc.BlockSize())
-	if paddingLen != 0 {
-		blockCount++
-	}
-	// initialize HMAC
+	// encrypt the file in chunks, computing the checksum of the plaintext and
+	// the HMAC of the ciphertext as it is written
+	sum := sha256.New()
 	mac := hmac.New(sha256.New, v.key)
-	// encrypt block by block
-	for i := int64(0); i < blockCount; i++ {
-		// read the file on disk into the src buffer
-		if n, err := io.ReadFull(src, srcBuff); err != nil {
-			// not all bytes were read
-			if i != blockCount-1 {
-				return nil, fmt.Errorf("file read error: %s", err.Error())
-			}
-			if n != enc.BlockSize() {
-				// pad the buffer
-				copy(srcBuff[n:], make([]byte, enc.BlockSize()-n))
-			}
+	bs := enc.BlockSize()
+	err = cryptPipeline(src, stat.Size(), io.MultiWriter(dst, mac), func(chunk []byte, last bool) []byte {
+		sum.Write(chunk)
+		// pad the last block with zeros
+		if n := len(chunk); n%bs != 0 {
+			chunk = chunk[:n+bs-n%bs]
+			copy(chunk[n:], make([]byte, bs-n%bs))
 		}
-		// crypt the src buffer into the dst buffer
-		enc.CryptBlocks(dstBuff, srcBuff)
-		mac.Write(dstBuff) // write ciphertext to hmac
-		// write the dst buffer to dst file on disk
-		dst.WriteAt(dstBuff, i*int64(enc.BlockSize()))
+		enc.CryptBlocks(chunk, chunk)
+		return chunk
+	})
+	if err != nil {
+		return nil, err
 	}
-	// store the HMAC in the file entry
+	// store the checksum and HMAC in the file entry
+	fileEntry.Checksum = sum.Sum(nil)
 	fileEntry.HMAC = mac.Sum(nil)
 	return fileEntry, nil
 }
 
-func (v *AESVault) decrypt(srcEntry *AESVaultEntry, src, dst *os.File) error {
+// decrypt decrypts the ciphertext src, corresponding to srcEntry, writing the
+// plaintext to dst.
+// Note that the plaintext is written before the ciphertext is authenticated,
+// so dst must be discarded if an error is returned.
+func (v *AESVault) decrypt(srcEntry *AESVaultEntry, src *os.File, dst io.Writer) error {
 	// stat the src file to get size and determine number of ciphertext blocks
 	info, err := src.Stat()
 	if err != nil {
@@ -78,30 +76,114 @@ func (v *AESVault) decrypt(srcEntry *AESVaultEntry, src, dst *os.File) error {
 	if err != nil {
 		return fmt.Errorf("failed to initialize decryptor: %s", err.Error())
 	}
-	numBlocks := info.Size() / int64(dec.BlockSize())
-	srcBuff := make([]byte, dec.BlockSize())
-	dstBuff := make([]byte, dec.BlockSize())
-	// initialize HMAC
+	// decrypt the whole blocks of the file in chunks, computing the HMAC of the
+	// ciphertext as it is read
+	size := info.Size() - info.Size()%int64(dec.BlockSize())
+	if srcEntry.Padding < 0 || srcEntry.Padding >= int64(dec.BlockSize()) || srcEntry.Padding > size {
+		return fmt.Errorf("invalid padding %d for a ciphertext of %d bytes", srcEntry.Padding, info.Size())
+	}
 	mac := hmac.New(sha256.New, v.key)
-	for i := int64(0); i < numBlocks; i++ {
-		// read data into the src buffer from the src file
-		if _, err := io.ReadFull(src, srcBuff); err != nil {
-			return fmt.Errorf("src file read error: %s", err.Error())
-		}
-		mac.Write(srcBuff) // write ciphertext to hmac
-		// decrypt the src buffer into the dst buffer
-		dec.CryptBlocks(dstBuff, srcBuff)
-		// remove padding from last block, if any
-		if i == numBlocks-1 && srcEntry.Padding != 0 {
-			dstBuff = dstBuff[:(dec.BlockSize() - int(srcEntry.Padding))]
+	err = cryptPipeline(io.TeeReader(src, mac), size, dst, func(chunk []byte, last bool) []byte {
+		dec.CryptBlocks(chunk, chunk)
+		// remove padding from the last block, if any
+		if last && srcEntry.Padding != 0 {
+			chunk = chunk[:len(chunk)-int(srcEntry.Padding)]
 		}
-		// write dst buff to file
-		dst.WriteAt(dstBuff, i*int64(dec.BlockSize()))
+		return chunk
+	})
+	if err != nil {
+		return err
 	}
 	// verify that the ciphertext hmac is the same as the one in the srcR
 	hmacTag := mac.Sum(nil)
 	if !hmac.Equal(hmacTag, srcEntry.HMAC) {
-		return fmt.Errorf("ciphertext auth fail - possibility of tampering")
+		return errAuthFailed
+	}
+	return nil
+}
+
+// cryptChunkSize is the size of the chunks in which files are encrypted and
+// decrypted; it is a multiple of the AES block size.
+const cryptChunkSize = 1 << 20
+
+// cryptPipelineDepth is the number of chunks which are buffered between the
+// stages of the pipeline.
+const cryptPipelineDepth = 4
+
+// cryptPipeline reads size bytes from src in chunks, calls process on each
+// chunk (in order) and writes the chunk it returns to dst.
+// process may modify the chunk in place, and extend it up to cryptChunkSize;
+// last is set for the last chunk.
+// Reading, processing and writing run concurrently, so that the disk is kept
+// busy while chunks are being processed.
+func cryptPipeline(src io.Reader, size int64, dst io.Writer, process func(chunk []byte, last bool) []byte) error {
+	type chunk struct {
+		buf  []byte
+		data []byte
+		last bool
+	}
+	free := make(chan []byte, cryptPipelineDepth+2)
+	for i := 0; i < cap(free); i++ {
+		free <- make([]byte, cryptChunkSize)
+	}
+	read := make(chan chunk, cryptPipelineDepth)
+	written := make(chan chunk, cryptPipelineDepth)
+	// stop is closed when writing fails, to stop reading
+	stop := make(chan struct{})
+
+	var readErr error
+	go func() {
+		defer close(read)
+		for remaining := size; remaining > 0; {
+			var buf []byte
+			select {
+			case buf = <-free:
+			case <-stop:
+				return
+			}
+			n := int64(len(buf))
+			if remaining < n {
+				n = remaining
+			}
+			if _, err := io.ReadFull(src, buf[:n]); err != nil {
+				readErr = err
+				return
+			}
+			remaining -= n
+			select {
+			case read <- chunk{buf: buf, data: buf[:n], last: remaining == 0}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var writeErr error
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for c := range written {
+			if writeErr == nil {
+				if _, err := dst.Write(c.data); err != nil {
+					writeErr = err
+					close(stop)
+				}
+			}
+			free <- c.buf
+		}
+	}()
+
+	for c := range read {
+		c.data = process(c.data, c.last)
+		written <- c
+	}
+	close(written)
+	<-writerDone
+	if readErr != nil {
+		return fmt.Errorf("file read error: %s", readErr.Error())
+	}
+	if writeErr != nil {
+		return fmt.Errorf("file write error: %s", writeErr.Error())
 	}
 	return nil
 }
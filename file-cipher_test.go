@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, fmt.Errorf("disk full") }
+
+var cryptSizes = []struct {
+	name string
+	size int
+}{
+	{"empty", 0},
+	{"one byte", 1},
+	{"one block", int(AES_BS)},
+	{"unaligned", 1000},
+	{"one chunk", cryptChunkSize},
+	{"chunk and a byte", cryptChunkSize + 1},
+	{"multi-chunk unaligned", 3*cryptChunkSize + 7},
+}
+
+func TestCryptPipeline(t *testing.T) {
+	for _, tc := range cryptSizes {
+		t.Run(tc.name, func(t *testing.T) {
+			data := randomData(tc.size)
+			var out bytes.Buffer
+			var chunks, lasts int
+			err := cryptPipeline(bytes.NewReader(data), int64(len(data)), &out, func(chunk []byte, last bool) []byte {
+				chunks++
+				if last {
+					lasts++
+				}
+				for i := range chunk {
+					chunk[i] ^= 0xff
+				}
+				return chunk
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([]byte, len(data))
+			for i := range data {
+				want[i] = data[i] ^ 0xff
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("output does not match the processed input")
+			}
+			wantChunks := (tc.size + cryptChunkSize - 1) / cryptChunkSize
+			if chunks != wantChunks {
+				t.Errorf("got %d chunks, want %d", chunks, wantChunks)
+			}
+			if tc.size != 0 && lasts != 1 {
+				t.Errorf("got %d last chunks, want 1", lasts)
+			}
+		})
+	}
+}
+
+func TestCryptPipelineErrors(t *testing.T) {
+	identity := func(chunk []byte, last bool) []byte { return chunk }
+	data := randomData(3 * cryptChunkSize)
+	if err := cryptPipeline(bytes.NewReader(data[:cryptChunkSize]), int64(len(data)), ioutil.Discard, identity); err == nil {
+		t.Errorf("short input: expected a read error")
+	}
+	if err := cryptPipeline(bytes.NewReader(data), int64(len(data)), failingWriter{}, identity); err == nil {
+		t.Errorf("failing output: expected a write error")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	v := newTestVault(t)
+	for _, tc := range cryptSizes {
+		t.Run(tc.name, func(t *testing.T) {
+			data := randomData(tc.size)
+			entry := addTestFile(t, v, tc.name, data)
+			if entry.Size != int64(tc.size) || (entry.Size+entry.Padding)%AES_BS != 0 {
+				t.Errorf("got size %d and padding %d for %d bytes", entry.Size, entry.Padding, tc.size)
+			}
+			var out bytes.Buffer
+			if err := v.readEntry(entry, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Errorf("decrypted data does not match the original")
+			}
+		})
+	}
+}
+
+func TestDecryptCorrupt(t *testing.T) {
+	v := newTestVault(t)
+	entry := addTestFile(t, v, "file", randomData(1000))
+
+	// an invalid padding is reported rather than panicking
+	for _, padding := range []int64{-1, AES_BS, 1 << 20} {
+		bad := *entry
+		bad.Padding = padding
+		if err := v.readEntry(&bad, ioutil.Discard); err == nil {
+			t.Errorf("padding %d: expected an error", padding)
+		}
+	}
+
+	// a modified ciphertext fails authentication
+	p := v.ciphertextPath(entry)
+	ciphertext, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext[10] ^= 1
+	if err := ioutil.WriteFile(p, ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.readEntry(entry, ioutil.Discard); err != errAuthFailed {
+		t.Errorf("got error %v, want %v", err, errAuthFailed)
+	}
+
+	// the existing output is left untouched, and no plaintext is left behind
+	dir := t.TempDir()
+	output := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(output, []byte("previous"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.retrieveEntry(entry, output); err != errAuthFailed {
+		t.Errorf("got error %v, want %v", err, errAuthFailed)
+	}
+	if data, err := ioutil.ReadFile(output); err != nil || string(data) != "previous" {
+		t.Errorf("the output was modified: %q, %v", data, err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("got %d files in the output directory, want 1", len(files))
+	}
+}
+
+func TestRetrieveEntryMode(t *testing.T) {
+	v := newTestVault(t)
+	src := writeTestFile(t, "key", []byte("secret"))
+	modes := []os.FileMode{0600, 0644}
+	if os.Geteuid() == 0 {
+		// only readable by root
+		modes = append(modes, 0)
+	}
+	for _, mode := range modes {
+		if err := os.Chmod(src, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.addFile(src, "key"); err != nil {
+			t.Fatal(err)
+		}
+		_, entry := v.lookupFile("key")
+		output := filepath.Join(t.TempDir(), "key")
+		if err := v.retrieveEntry(entry, output); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("got mode %#o, want %#o", info.Mode().Perm(), mode)
+		}
+	}
+}
+
+const benchmarkSize = 16 << 20
+
+func BenchmarkCryptPipeline(b *testing.B) {
+	data := randomData(benchmarkSize)
+	b.SetBytes(benchmarkSize)
+	for i := 0; i < b.N; i++ {
+		err := cryptPipeline(bytes.NewReader(data), benchmarkSize, ioutil.Discard, func(chunk []byte, last bool) []byte {
+			return chunk
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	v := newTestVault(b)
+	src, err := os.Open(writeTestFile(b, "src", randomData(benchmarkSize)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := v.createCiphertext()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer dst.Close()
+	b.SetBytes(benchmarkSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := src.Seek(0, 0); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := dst.Seek(0, 0); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := v.encrypt("src", src, dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	v := newTestVault(b)
+	entry := addTestFile(b, v, "src", randomData(benchmarkSize))
+	b.SetBytes(benchmarkSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := v.readEntry(entry, ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
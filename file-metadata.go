@@ -0,0 +1,82 @@
+package main
+
+import "os"
+
+// MetadataOptions selects the file metadata which is recorded when a file is
+// added to the vault, and restored when it is retrieved.
+type MetadataOptions struct {
+	// permission bits (and setuid/setgid/sticky bits)
+	Mode bool
+	// modification time
+	ModTime bool
+	// numeric owner and group; restoring these usually requires privileges
+	Owner bool
+	// extended attributes in the "user." namespace (where supported)
+	Xattrs bool
+}
+
+// DefaultMetadataOptions are the metadata options a vault is opened with.
+var DefaultMetadataOptions = MetadataOptions{
+	Mode:    true,
+	ModTime: true,
+	Xattrs:  true,
+}
+
+// SetMetadataOptions sets the file metadata which is recorded and restored by
+// subsequent operations on the vault.
+func (v *AESVault) SetMetadataOptions(opts MetadataOptions) {
+	v.meta = opts
+}
+
+// recordMetadata stores the metadata of the file at srcPath, as selected by
+// the vault's metadata options, in entry.
+func (v *AESVault) recordMetadata(entry *AESVaultEntry, srcPath string) error {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	if v.meta.Mode {
+		entry.Mode = info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		entry.ModeSet = true
+	}
+	if v.meta.ModTime {
+		entry.ModTime = info.ModTime()
+	}
+	if v.meta.Owner {
+		entry.Owner = fileOwner(info)
+	}
+	if v.meta.Xattrs {
+		if entry.Xattrs, err = readXattrs(srcPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreMetadata applies the metadata stored in entry, as selected by the
+// vault's metadata options, to the file at dstPath.
+// Metadata which was not recorded for the entry is left untouched.
+func (v *AESVault) restoreMetadata(entry *AESVaultEntry, dstPath string) error {
+	if v.meta.Xattrs && len(entry.Xattrs) != 0 {
+		if err := writeXattrs(dstPath, entry.Xattrs); err != nil {
+			return err
+		}
+	}
+	// changing the owner may clear the setuid/setgid bits, so it precedes chmod
+	if v.meta.Owner && entry.Owner != nil {
+		if err := os.Lchown(dstPath, entry.Owner.Uid, entry.Owner.Gid); err != nil {
+			return err
+		}
+	}
+	if v.meta.Mode && entry.hasMode() {
+		if err := os.Chmod(dstPath, entry.Mode); err != nil {
+			return err
+		}
+	}
+	if v.meta.ModTime && !entry.ModTime.IsZero() {
+		if err := os.Chtimes(dstPath, entry.ModTime, entry.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
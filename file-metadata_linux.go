@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// xattrPrefix is the namespace of the extended attributes which are recorded.
+// Attributes in other namespaces (e.g. "security.", "trusted.") are managed by
+// the system and generally cannot be restored by unprivileged users.
+const xattrPrefix = "user."
+
+func fileOwner(info os.FileInfo) *FileOwner {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &FileOwner{Uid: int(stat.Uid), Gid: int(stat.Gid)}
+}
+
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err == syscall.ENOTSUP || size == 0 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := make([]byte, size)
+	if size, err = syscall.Listxattr(path, names); err != nil {
+		return nil, err
+	}
+	attrs := make(map[string][]byte)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if !strings.HasPrefix(string(name), xattrPrefix) {
+			continue
+		}
+		valSize, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		val := make([]byte, valSize)
+		if valSize, err = syscall.Getxattr(path, string(name), val); err != nil {
+			return nil, err
+		}
+		attrs[string(name)] = val[:valSize]
+	}
+	return attrs, nil
+}
+
+func writeXattrs(path string, attrs map[string][]byte) error {
+	for name, val := range attrs {
+		err := syscall.Setxattr(path, name, val, 0)
+		if err == syscall.ENOTSUP {
+			// the file system does not support extended attributes (e.g.
+			// tmpfs, vfat, NFS), which are skipped as when they are read
+			return nil
+		} else if err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: path, Err: err}
+		}
+	}
+	return nil
+}
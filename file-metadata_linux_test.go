@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRetrieveXattrs(t *testing.T) {
+	v := newTestVault(t)
+	src := writeTestFile(t, "src", randomData(100))
+	if err := syscall.Setxattr(src, "user.test", []byte("value"), 0); err == syscall.ENOTSUP {
+		t.Skip("extended attributes are not supported by the file system")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.addFile(src, "x"); err != nil {
+		t.Fatal(err)
+	}
+	_, entry := v.lookupFile("x")
+	out := filepath.Join(t.TempDir(), "out")
+	if err := v.retrieveEntry(entry, out); err != nil {
+		t.Fatal(err)
+	}
+	val := make([]byte, 16)
+	n, err := syscall.Getxattr(out, "user.test", val)
+	if err != nil || string(val[:n]) != "value" {
+		t.Errorf("got attribute %q (%v), want \"value\"", val[:n], err)
+	}
+}
+
+func TestRetrieveMetadataError(t *testing.T) {
+	v := newTestVault(t)
+	entry := addTestFile(t, v, "x", randomData(100))
+	// an attribute name which is too long cannot be set
+	entry.Xattrs = map[string][]byte{xattrPrefix + strings.Repeat("a", 300): []byte("value")}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	old := []byte("previous contents")
+	if err := ioutil.WriteFile(out, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.retrieveEntry(entry, out); err == nil {
+		t.Fatal("restoring an invalid attribute did not fail")
+	}
+	if got, err := ioutil.ReadFile(out); err != nil || !bytes.Equal(got, old) {
+		t.Errorf("the output file was replaced (%v)", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files in the output directory, want 1", len(files))
+	}
+}
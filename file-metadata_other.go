@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// Ownership and extended attributes are only recorded on Linux.
+
+func fileOwner(info os.FileInfo) *FileOwner { return nil }
+
+func readXattrs(path string) (map[string][]byte, error) { return nil, nil }
+
+func writeXattrs(path string, attrs map[string][]byte) error { return nil }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Before gringotts had subcommands, commands were given as flags (e.g.
+// "--vault secrets --encrypt file.txt").
+// These flags are still accepted, but deprecated: they are translated to the
+// corresponding subcommand invocation, which is then run.
+
+// legacyCommand maps a deprecated command flag to its subcommand.
+type legacyCommand struct {
+	flag    string
+	command string
+	// setting is the first argument of the subcommand, if any (for commands
+	// which have become settings of the "set" command)
+	setting string
+}
+
+var legacyCommands = []legacyCommand{
+	{flag: "create", command: "create"},
+	{flag: "list", command: "ls"},
+	{flag: "encrypt", command: "add"},
+	{flag: "decrypt", command: "get"},
+	{flag: "remove", command: "rm"},
+	{flag: "cat", command: "cat"},
+	{flag: "view", command: "view"},
+	{flag: "edit", command: "edit"},
+	{flag: "rename", command: "mv"},
+	{flag: "history", command: "history"},
+	{flag: "tag", command: "tag"},
+	{flag: "search", command: "search"},
+	{flag: "snapshot", command: "snapshot"},
+	{flag: "fulltext", command: "set", setting: "fulltext"},
+	{flag: "retention", command: "set", setting: "retention"},
+	{flag: "parity", command: "set", setting: "parity"},
+	{flag: "cleanup", command: "gc"},
+	{flag: "prune-entries", command: "prune"},
+	{flag: "integrity", command: "verify"},
+	{flag: "repair", command: "repair"},
+	{flag: "scrub", command: "scrub"},
+	{flag: "health", command: "health"},
+	{flag: "fsck", command: "fsck"},
+	{flag: "shell", command: "shell"},
+}
+
+// legacyFlagSet returns a flag set defining the deprecated command flags, as
+// well as the options of all the subcommands.
+func legacyFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("gringotts", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Bool("help", false, "display help menu")
+	fs.String("vault", "", "name of the vault to operate on")
+	for _, lc := range legacyCommands {
+		switch lc.flag {
+		case "list", "cleanup", "prune-entries", "integrity", "repair", "scrub", "health", "fsck", "shell":
+			fs.Bool(lc.flag, false, "")
+		default:
+			fs.String(lc.flag, "", "")
+		}
+	}
+	// define the options of the subcommands, skipping the ones already defined
+	for _, cmd := range commands {
+		cmdFlags, _ := newFlagSet(cmd)
+		cmdFlags.VisitAll(func(f *flag.Flag) {
+			if fs.Lookup(f.Name) == nil {
+				fs.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+	}
+	return fs
+}
+
+// runLegacy translates the deprecated command line args (excluding the program
+// name) to a subcommand invocation and runs it, returning the exit code.
+// Unlike before, giving more than one command flag is an error.
+func runLegacy(args []string) int {
+	fs := legacyFlagSet()
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "gringotts: %s, use 'gringotts help'\n", err.Error())
+		return exitUsage
+	}
+	if isFlagSet(fs, "help") {
+		usage()
+		return exitOK
+	}
+	// determine the (single) command to run
+	var lc *legacyCommand
+	for i := range legacyCommands {
+		if !isFlagSet(fs, legacyCommands[i].flag) {
+			continue
+		}
+		if lc != nil {
+			fmt.Fprintf(os.Stderr, "gringotts: conflicting commands --%s and --%s\n", lc.flag, legacyCommands[i].flag)
+			return exitUsage
+		}
+		lc = &legacyCommands[i]
+	}
+	if lc == nil {
+		fmt.Fprintf(os.Stderr, "gringotts: expected command, use 'gringotts help'\n")
+		return exitUsage
+	}
+	cmd := lookupCommand(lc.command)
+	fmt.Fprintf(os.Stderr, "warning: --%s is deprecated, use 'gringotts %s' instead\n", lc.flag, lc.command)
+	// translate the command flag's value to the first positional argument
+	var positional []string
+	if lc.setting != "" {
+		positional = append(positional, lc.setting)
+	}
+	value := fs.Lookup(lc.flag).Value
+	if b, ok := value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+		positional = append(positional, value.String())
+	}
+	// pass on the options understood by the subcommand; as before, options
+	// which do not apply to the command are ignored
+	cmdFlags, _ := newFlagSet(cmd)
+	translated := []string{cmd.name}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == lc.flag || cmdFlags.Lookup(f.Name) == nil {
+			return
+		}
+		if kv, ok := f.Value.(keyValueFlag); ok {
+			for k, v := range kv {
+				translated = append(translated, fmt.Sprintf("--%s=%s=%s", f.Name, k, v))
+			}
+			return
+		}
+		translated = append(translated, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+	})
+	translated = append(translated, "--")
+	translated = append(translated, positional...)
+	translated = append(translated, fs.Args()...)
+	return run(translated)
+}
+
+// legacyUsage describes the deprecated command flags.
+func legacyUsage() string {
+	var b strings.Builder
+	for _, lc := range legacyCommands {
+		command := lc.command
+		if lc.setting != "" {
+			command += " " + lc.setting
+		}
+		fmt.Fprintf(&b, "  --%-15s -> gringotts %s\n", lc.flag, command)
+	}
+	return b.String()
+}
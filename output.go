@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Output formats of the commands which display results.
+const (
+	formatText = "text"
+	// a single JSON document
+	formatJSON = "json"
+	// newline-delimited JSON, one document per listed item, for streaming
+	formatNDJSON = "ndjson"
+)
+
+// formatFlag defines the --format flag, and returns a function which returns
+// the selected output format (or a usage error if it is unknown).
+func formatFlag(fs *flag.FlagSet) func() (string, error) {
+	format := fs.String("format", formatText, "output format: text, json or ndjson (defaults to the configured format)")
+	return func() (string, error) {
+		if !isFlagSet(fs, "format") && config.Format != "" {
+			*format = config.Format
+		}
+		switch *format {
+		case formatText, formatJSON, formatNDJSON:
+			return *format, nil
+		}
+		return "", usageErrorf("unknown output format '%s'", *format)
+	}
+}
+
+// The following types define the JSON schema of the results of commands.
+// Fields are only ever added to them, so that the schema remains stable.
+
+// entryJSON describes a (version of a) file in the vault.
+type entryJSON struct {
+	Name    string            `json:"name"`
+	Version int               `json:"version"`
+	Size    int64             `json:"size"`
+	SHA256  string            `json:"sha256,omitempty"`
+	Added   *time.Time        `json:"added,omitempty"`
+	Mode    string            `json:"mode,omitempty"`
+	ModTime *time.Time        `json:"mtime,omitempty"`
+	UID     *int              `json:"uid,omitempty"`
+	GID     *int              `json:"gid,omitempty"`
+	Xattrs  []string          `json:"xattrs,omitempty"`
+	Tags    []string          `json:"tags"`
+	Note    string            `json:"note,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+func newEntryJSON(e *AESVaultEntry) entryJSON {
+	j := entryJSON{
+		Name:    e.Filename,
+		Version: e.Version,
+		Size:    e.Size,
+		Tags:    e.Tags,
+		Note:    e.Note,
+		Meta:    e.Meta,
+	}
+	if j.Tags == nil {
+		j.Tags = []string{}
+	}
+	if e.Checksum != nil {
+		j.SHA256 = hex.EncodeToString(e.Checksum)
+	}
+	if !e.Added.IsZero() {
+		j.Added = &e.Added
+	}
+	if e.hasMode() {
+		j.Mode = fmt.Sprintf("%#o", uint32(e.Mode.Perm()))
+	}
+	if !e.ModTime.IsZero() {
+		j.ModTime = &e.ModTime
+	}
+	if e.Owner != nil {
+		j.UID, j.GID = &e.Owner.Uid, &e.Owner.Gid
+	}
+	for name := range e.Xattrs {
+		j.Xattrs = append(j.Xattrs, name)
+	}
+	sort.Strings(j.Xattrs)
+	return j
+}
+
+func newEntriesJSON(entries []*AESVaultEntry) []entryJSON {
+	list := make([]entryJSON, len(entries))
+	for i, e := range entries {
+		list[i] = newEntryJSON(e)
+	}
+	return list
+}
+
+// printEntries displays entries in the given format, calling text to display
+// each entry in the text format.
+func printEntries(format string, entries []*AESVaultEntry, text func(entry *AESVaultEntry)) error {
+	list := newEntriesJSON(entries)
+	items := make([]interface{}, len(list))
+	for i := range list {
+		items[i] = list[i]
+	}
+	return printList(format, map[string][]entryJSON{"files": list}, items, func() {
+		for _, entry := range entries {
+			text(entry)
+		}
+	})
+}
+
+// snapshotJSON describes a snapshot of the vault.
+type snapshotJSON struct {
+	Label   string    `json:"label"`
+	Created time.Time `json:"created"`
+	Files   int       `json:"files"`
+}
+
+func newSnapshotsJSON(snapshots []*Snapshot) []snapshotJSON {
+	list := make([]snapshotJSON, len(snapshots))
+	for i, snap := range snapshots {
+		list[i] = snapshotJSON{Label: snap.Label, Created: snap.Created, Files: len(snap.Files)}
+	}
+	return list
+}
+
+// infoJSON describes a vault.
+type infoJSON struct {
+	Vault       string         `json:"vault"`
+	Path        string         `json:"path"`
+	Encryption  string         `json:"encryption"`
+	KDF         string         `json:"kdf"`
+	Files       int            `json:"files"`
+	Versions    int            `json:"versions"`
+	Size        int64          `json:"size"`
+	MaxVersions int            `json:"max_versions"`
+	FullText    bool           `json:"fulltext"`
+	Parity      int            `json:"parity"`
+	Trash       int            `json:"trash"`
+	TrashDays   int            `json:"trash_days"`
+	Snapshots   []snapshotJSON `json:"snapshots"`
+}
+
+func newInfoJSON(name string, v *AESVault) infoJSON {
+	info := infoJSON{
+		Vault:       name,
+		Path:        v.dirName,
+		Encryption:  encryptionName(v.Encryption),
+		KDF:         v.params.KDF.Name,
+		Versions:    len(v.Files),
+		MaxVersions: v.MaxVersions,
+		FullText:    v.FullText,
+		Parity:      v.ParityOverhead,
+		Trash:       len(v.Trash),
+		TrashDays:   v.TrashDays,
+		Snapshots:   newSnapshotsJSON(v.Snapshots),
+	}
+	if abs, err := filepath.Abs(v.dirName); err == nil {
+		info.Path = abs
+	}
+	for _, entry := range v.latestEntries() {
+		info.Files++
+		info.Size += entry.Size
+	}
+	return info
+}
+
+// registeredVaultJSON describes a vault registered in the config file.
+type registeredVaultJSON struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Default bool   `json:"default"`
+}
+
+// testResultJSON describes the result of the integrity test of a file.
+type testResultJSON struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Damage  string `json:"damage,omitempty"`
+}
+
+func newTestResultJSON(r EntryIntegrity) testResultJSON {
+	return testResultJSON{Name: r.Entry.Filename, Version: r.Entry.Version, Status: r.Status, Reason: r.Reason, Damage: r.Damage}
+}
+
+// integrityJSON describes the result of an integrity test of the vault.
+type integrityJSON struct {
+	Total         int              `json:"total"`
+	Passed        int              `json:"passed"`
+	Failed        int              `json:"failed"`
+	Inconclusive  int              `json:"inconclusive"`
+	Repairable    int              `json:"repairable"`
+	Unrecoverable int              `json:"unrecoverable"`
+	Results       []testResultJSON `json:"results"`
+}
+
+func newIntegrityJSON(results []EntryIntegrity) integrityJSON {
+	report := integrityJSON{Total: len(results), Results: []testResultJSON{}}
+	for _, r := range results {
+		switch r.Status {
+		case statusPassed:
+			report.Passed++
+		case statusFailed:
+			report.Failed++
+		default:
+			report.Inconclusive++
+		}
+		switch r.Damage {
+		case damageRepairable:
+			report.Repairable++
+		case damageUnrecoverable:
+			report.Unrecoverable++
+		}
+		report.Results = append(report.Results, newTestResultJSON(r))
+	}
+	return report
+}
+
+// repairResultJSON describes the result of checking (and repairing) a
+// ciphertext with its parity data. Its status is "intact", "repaired",
+// "unrecoverable" or "error".
+type repairResultJSON struct {
+	Name      string `json:"name"`
+	Version   int    `json:"version"`
+	Status    string `json:"status"`
+	Damaged   int    `json:"damaged_chunks"`
+	WrongSize bool   `json:"wrong_size,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newRepairResultJSON(r RepairResult) repairResultJSON {
+	result := repairResultJSON{Name: r.Entry.Filename, Version: r.Entry.Version, Status: "intact", Damaged: r.Scan.Damaged, WrongSize: r.Scan.BadSize}
+	switch {
+	case r.Err != nil:
+		result.Status, result.Error = "error", r.Err.Error()
+	case r.Repaired:
+		result.Status = "repaired"
+	case !r.Scan.Intact():
+		result.Status = damageUnrecoverable
+	}
+	return result
+}
+
+// repairJSON describes the result of repairing the ciphertexts of the vault.
+type repairJSON struct {
+	Checked       int                `json:"checked"`
+	Repaired      int                `json:"repaired"`
+	Unrecoverable int                `json:"unrecoverable"`
+	Errors        int                `json:"errors"`
+	Results       []repairResultJSON `json:"results"`
+}
+
+func newRepairJSON(results []RepairResult) repairJSON {
+	report := repairJSON{Checked: len(results), Results: []repairResultJSON{}}
+	for _, r := range results {
+		result := newRepairResultJSON(r)
+		switch result.Status {
+		case "repaired":
+			report.Repaired++
+		case damageUnrecoverable:
+			report.Unrecoverable++
+		case "error":
+			report.Errors++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// scrubJSON describes the result of a scrub of the vault: the integrity test
+// results, the size of the tested ciphertexts, the number of remaining files
+// and the repairs.
+type scrubJSON struct {
+	integrityJSON
+	Bytes     int64              `json:"bytes"`
+	Remaining int                `json:"remaining"`
+	Repairs   []repairResultJSON `json:"repairs"`
+}
+
+func newScrubJSON(scrub *ScrubResult) scrubJSON {
+	report := scrubJSON{
+		integrityJSON: newIntegrityJSON(scrub.Results),
+		Bytes:         scrub.Bytes,
+		Remaining:     scrub.Remaining,
+		Repairs:       []repairResultJSON{},
+	}
+	for _, r := range scrub.Repairs {
+		report.Repairs = append(report.Repairs, newRepairResultJSON(r))
+	}
+	return report
+}
+
+// healthEntryJSON describes the last recorded integrity test of a file.
+type healthEntryJSON struct {
+	Name     string     `json:"name"`
+	Version  int        `json:"version"`
+	Added    *time.Time `json:"added,omitempty"`
+	Verified *time.Time `json:"verified,omitempty"`
+	Status   string     `json:"status,omitempty"`
+	Reason   string     `json:"reason,omitempty"`
+	Repaired *time.Time `json:"repaired,omitempty"`
+}
+
+func newHealthEntryJSON(e *AESVaultEntry) healthEntryJSON {
+	j := healthEntryJSON{Name: e.Filename, Version: e.Version}
+	if !e.Added.IsZero() {
+		j.Added = &e.Added
+	}
+	if r := e.Verified; r != nil {
+		j.Verified, j.Status, j.Reason = &r.Time, r.Status, r.Reason
+		if !r.Repaired.IsZero() {
+			j.Repaired = &r.Repaired
+		}
+	}
+	return j
+}
+
+func newHealthEntriesJSON(entries []*AESVaultEntry) []healthEntryJSON {
+	list := make([]healthEntryJSON, len(entries))
+	for i, e := range entries {
+		list[i] = newHealthEntryJSON(e)
+	}
+	return list
+}
+
+// healthJSON describes the health report of the vault.
+type healthJSON struct {
+	Healthy       bool              `json:"healthy"`
+	Files         int               `json:"files"`
+	StaleDays     int               `json:"stale_days"`
+	NeverVerified []healthEntryJSON `json:"never_verified"`
+	Stale         []healthEntryJSON `json:"stale"`
+	Failed        []healthEntryJSON `json:"failed"`
+	Repaired      []healthEntryJSON `json:"repaired"`
+}
+
+func newHealthJSON(h *HealthReport) healthJSON {
+	return healthJSON{
+		Healthy:       h.Healthy(),
+		Files:         h.Entries,
+		StaleDays:     int(h.StaleAfter / (24 * time.Hour)),
+		NeverVerified: newHealthEntriesJSON(h.NeverVerified),
+		Stale:         newHealthEntriesJSON(h.Stale),
+		Failed:        newHealthEntriesJSON(h.Failed),
+		Repaired:      newHealthEntriesJSON(h.Repaired),
+	}
+}
+
+// cleanupJSON describes the ciphertexts deleted by the gc command (or which
+// would be deleted, for a dry run).
+type cleanupJSON struct {
+	Deleted []string `json:"deleted"`
+	DryRun  bool     `json:"dry_run,omitempty"`
+	// Trash is set if the ciphertexts are moved to the trash
+	Trash bool `json:"trash,omitempty"`
+}
+
+// pruneJSON describes the entries removed by the prune command (or which would
+// be removed, for a dry run).
+type pruneJSON struct {
+	Pruned []entryJSON `json:"pruned"`
+	DryRun bool        `json:"dry_run,omitempty"`
+	// Trash is set if the entries are moved to the trash
+	Trash bool `json:"trash,omitempty"`
+}
+
+// fsckProblemJSON describes a problem found by the fsck command.
+type fsckProblemJSON struct {
+	Class   string `json:"class"`
+	Name    string `json:"name"`
+	Detail  string `json:"detail"`
+	Fixable bool   `json:"fixable"`
+	Fixed   bool   `json:"fixed,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newFsckProblemJSON(p *FsckProblem) fsckProblemJSON {
+	j := fsckProblemJSON{Class: p.Class, Name: p.Name, Detail: p.Detail, Fixable: p.Fixable(), Fixed: p.Fixed}
+	if p.FixErr != nil {
+		j.Error = p.FixErr.Error()
+	}
+	return j
+}
+
+// fsckJSON describes the problems found (and fixed) by the fsck command.
+type fsckJSON struct {
+	Problems []fsckProblemJSON `json:"problems"`
+	Fixed    int               `json:"fixed"`
+	// Remaining is the number of problems which were not fixed
+	Remaining int `json:"remaining"`
+}
+
+// trashItemJSON describes an item in the trash: a file entry, or an unlinked
+// ciphertext.
+type trashItemJSON struct {
+	Name       string    `json:"name,omitempty"`
+	Version    int       `json:"version,omitempty"`
+	Ciphertext string    `json:"ciphertext,omitempty"`
+	Deleted    time.Time `json:"deleted"`
+	Expires    time.Time `json:"expires"`
+}
+
+func newTrashItemJSON(item *TrashItem, days int) trashItemJSON {
+	j := trashItemJSON{Ciphertext: item.Ciphertext, Deleted: item.Deleted, Expires: item.Expires(days)}
+	if item.Entry != nil {
+		j.Name, j.Version = item.Entry.Filename, item.Entry.Version
+	}
+	return j
+}
+
+// printJSON writes v to stdout as an indented JSON document.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printNDJSON writes each of items to stdout as a JSON document on its own
+// line.
+func printNDJSON(items ...interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printList writes a list of items in the given format: as a JSON document
+// (doc, which contains the items) or as a stream of items.
+// For the text format, text is called to display the list.
+func printList(format string, doc interface{}, items []interface{}, text func()) error {
+	switch format {
+	case formatJSON:
+		return printJSON(doc)
+	case formatNDJSON:
+		return printNDJSON(items...)
+	}
+	text()
+	return nil
+}
+
+// encryptionName returns the display name of the AES variant.
+func encryptionName(enc encType) string {
+	switch enc {
+	case AES_256:
+		return "AES-256"
+	case AES_192:
+		return "AES-192"
+	case AES_128:
+		return "AES-128"
+	}
+	return fmt.Sprintf("unknown (%d)", enc)
+}
@@ -1,18 +1,41 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"os"
+	"strings"
 	"syscall"
 
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// getPassword reads a password from the terminal.
+// The prompt is written to stderr, so that it does not mix with the (possibly
+// machine-readable) output of commands.
 func getPassword(prompt string) ([]byte, error) {
-	fmt.Print(prompt)
+	fmt.Fprint(os.Stderr, prompt)
 	pwd, err := terminal.ReadPassword(int(syscall.Stdin))
-	fmt.Print("\n")
+	fmt.Fprint(os.Stderr, "\n")
 	if err != nil {
 		return nil, err
 	}
 	return pwd, nil
 }
+
+// stdin reads the answers to confirmations; it is shared by all of them, since
+// a reader may buffer several answers (piped to the command) at once.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks the user a yes/no question, returning true only if the answer
+// is yes.
+// Like the password prompt, the question is written to stderr.
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	answer, err := stdin.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,191 @@
+package main
+
+import "fmt"
+
+// A systematic Reed-Solomon erasure code over GF(2^8).
+// Data is split into k data shards, from which m parity shards are computed;
+// the data can be reconstructed from any k of the k+m shards, so up to m
+// damaged (or missing) shards can be repaired, provided it is known which
+// shards are damaged (which is why the shards are checksummed).
+// The parity shards are computed with a Cauchy matrix, every square submatrix
+// of which is invertible.
+
+// gfExp and gfLog are the exponential and logarithm tables of GF(2^8), with
+// the generator polynomial x^8 + x^4 + x^3 + x^2 + 1.
+var gfExp [512]byte
+var gfLog [256]byte
+
+// gfMulTable[a][b] is the product of a and b in GF(2^8).
+var gfMulTable [256][256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMulTable[a][b] = gfExp[int(gfLog[a])+int(gfLog[b])]
+		}
+	}
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// rsCode is a Reed-Solomon code with k data shards and m parity shards.
+type rsCode struct {
+	k, m int
+	// matrix is the (k+m) x k encoding matrix: the identity matrix (the data
+	// shards) followed by the Cauchy matrix (the parity shards)
+	matrix [][]byte
+}
+
+func newRSCode(k, m int) (*rsCode, error) {
+	if k < 1 || m < 1 || k+m > 256 {
+		return nil, fmt.Errorf("invalid number of shards (%d data, %d parity)", k, m)
+	}
+	c := &rsCode{k: k, m: m, matrix: make([][]byte, k+m)}
+	for i := range c.matrix {
+		c.matrix[i] = make([]byte, k)
+		if i < k {
+			c.matrix[i][i] = 1
+			continue
+		}
+		// 1 / (x_i + y_j), with x_i = i and y_j = j distinct elements
+		for j := 0; j < k; j++ {
+			c.matrix[i][j] = gfInv(byte(i) ^ byte(j))
+		}
+	}
+	return c, nil
+}
+
+// mulAdd adds the product of the coefficient c and the shard src to dst.
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	row := &gfMulTable[c]
+	for i, b := range src {
+		dst[i] ^= row[b]
+	}
+}
+
+// encode computes the parity shards from the data shards, which all have the
+// same length.
+func (c *rsCode) encode(data, parity [][]byte) {
+	for i := 0; i < c.m; i++ {
+		p := parity[i]
+		for j := range p {
+			p[j] = 0
+		}
+		for j := 0; j < c.k; j++ {
+			mulAdd(p, data[j], c.matrix[c.k+i][j])
+		}
+	}
+}
+
+// reconstruct recomputes the shards (data shards followed by parity shards)
+// which are marked as damaged, from the others.
+// It fails if more than m shards are damaged.
+func (c *rsCode) reconstruct(shards [][]byte, damaged []bool) error {
+	var rows []int
+	for i := 0; i < c.k+c.m && len(rows) < c.k; i++ {
+		if !damaged[i] {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < c.k {
+		return fmt.Errorf("too many damaged shards")
+	}
+	dataDamaged := false
+	for i := 0; i < c.k; i++ {
+		dataDamaged = dataDamaged || damaged[i]
+	}
+	if dataDamaged {
+		// the surviving shards are the product of the submatrix of their rows
+		// and the data shards, so the data shards are the product of its
+		// inverse and the surviving shards
+		sub := make([][]byte, c.k)
+		for i, r := range rows {
+			sub[i] = append([]byte(nil), c.matrix[r]...)
+		}
+		inv, err := gfInvertMatrix(sub)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < c.k; i++ {
+			if !damaged[i] {
+				continue
+			}
+			out := shards[i]
+			for j := range out {
+				out[j] = 0
+			}
+			for j, r := range rows {
+				mulAdd(out, shards[r], inv[i][j])
+			}
+		}
+	}
+	for i := 0; i < c.m; i++ {
+		if !damaged[c.k+i] {
+			continue
+		}
+		p := shards[c.k+i]
+		for j := range p {
+			p[j] = 0
+		}
+		for j := 0; j < c.k; j++ {
+			mulAdd(p, shards[j], c.matrix[c.k+i][j])
+		}
+	}
+	return nil
+}
+
+// gfInvertMatrix inverts the square matrix m (which is modified) using
+// Gauss-Jordan elimination.
+func gfInvertMatrix(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if m[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot == -1 {
+			return nil, fmt.Errorf("singular matrix")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+		scale := gfInv(m[col][col])
+		for j := 0; j < n; j++ {
+			m[col][j] = gfMulTable[scale][m[col][j]]
+			inv[col][j] = gfMulTable[scale][inv[col][j]]
+		}
+		for r := 0; r < n; r++ {
+			if r == col || m[r][col] == 0 {
+				continue
+			}
+			f := m[r][col]
+			mulAdd(m[r], m[col], f)
+			mulAdd(inv[r], inv[col], f)
+		}
+	}
+	return inv, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGFTables(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		if gfMulTable[a][0] != 0 || gfMulTable[0][a] != 0 {
+			t.Fatalf("%d * 0 is not 0", a)
+		}
+		if a == 0 {
+			continue
+		}
+		if gfMulTable[a][1] != byte(a) {
+			t.Fatalf("%d * 1 = %d", a, gfMulTable[a][1])
+		}
+		if p := gfMulTable[a][gfInv(byte(a))]; p != 1 {
+			t.Fatalf("%d * inverse(%d) = %d", a, a, p)
+		}
+		for b := 0; b < 256; b++ {
+			if gfMulTable[a][b] != gfMulTable[b][a] {
+				t.Fatalf("%d * %d is not commutative", a, b)
+			}
+			// a * (b + c) = a * b + a * c, for a few c
+			for _, c := range []int{1, 2, 0x53, 0xff} {
+				if gfMulTable[a][b^c] != gfMulTable[a][b]^gfMulTable[a][c] {
+					t.Fatalf("%d * (%d + %d) is not distributive", a, b, c)
+				}
+			}
+		}
+	}
+	// the generator has order 255
+	seen := make(map[byte]bool)
+	for i := 0; i < 255; i++ {
+		seen[gfExp[i]] = true
+	}
+	if len(seen) != 255 || seen[0] {
+		t.Errorf("the exponential table has %d distinct non-zero elements, want 255", len(seen))
+	}
+}
+
+func TestGFInvertMatrix(t *testing.T) {
+	c, err := newRSCode(8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the square submatrices of the encoding matrix are invertible
+	for _, rows := range [][]int{
+		{0, 1, 2, 3, 4, 5, 6, 7},
+		{8, 9, 10, 11, 12, 13, 14, 15},
+		{0, 2, 4, 6, 8, 10, 12, 14},
+		{1, 3, 5, 7, 9, 11, 13, 15},
+	} {
+		m := make([][]byte, len(rows))
+		orig := make([][]byte, len(rows))
+		for i, r := range rows {
+			m[i] = append([]byte(nil), c.matrix[r]...)
+			orig[i] = append([]byte(nil), c.matrix[r]...)
+		}
+		inv, err := gfInvertMatrix(m)
+		if err != nil {
+			t.Fatalf("rows %v: %s", rows, err.Error())
+		}
+		for i := range orig {
+			for j := range orig {
+				var p byte
+				for k := range orig {
+					p ^= gfMulTable[orig[i][k]][inv[k][j]]
+				}
+				want := byte(0)
+				if i == j {
+					want = 1
+				}
+				if p != want {
+					t.Fatalf("rows %v: (M * M^-1)[%d][%d] = %d, want %d", rows, i, j, p, want)
+				}
+			}
+		}
+	}
+	if _, err := gfInvertMatrix([][]byte{{1, 2}, {2, 4}}); err == nil {
+		t.Errorf("inverting a singular matrix: expected an error")
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	tests := []struct {
+		name    string
+		k, m    int
+		damaged []int
+		fail    bool
+	}{
+		{"intact", 4, 2, nil, false},
+		{"one data shard", 4, 2, []int{1}, false},
+		{"one parity shard", 4, 2, []int{5}, false},
+		{"data and parity", 4, 2, []int{0, 4}, false},
+		{"m data shards", 16, 3, []int{2, 9, 15}, false},
+		{"m parity shards", 16, 3, []int{16, 17, 18}, false},
+		{"all data shards", 16, 16, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, false},
+		{"single shards", 1, 1, []int{0}, false},
+		{"m+1 shards", 4, 2, []int{0, 1, 2}, true},
+		{"m+1 parity shards", 16, 3, []int{0, 16, 17, 18}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newRSCode(tc.k, tc.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shards := make([][]byte, tc.k+tc.m)
+			for i := range shards {
+				shards[i] = make([]byte, 100)
+			}
+			for i := 0; i < tc.k; i++ {
+				copy(shards[i], randomData(100+i))
+			}
+			c.encode(shards[:tc.k], shards[tc.k:])
+			orig := make([][]byte, len(shards))
+			for i := range shards {
+				orig[i] = append([]byte(nil), shards[i]...)
+			}
+			damaged := make([]bool, len(shards))
+			for _, i := range tc.damaged {
+				damaged[i] = true
+				for j := range shards[i] {
+					shards[i][j] ^= byte(j + 1)
+				}
+			}
+			err = c.reconstruct(shards, damaged)
+			if tc.fail {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range shards {
+				if !bytes.Equal(shards[i], orig[i]) {
+					t.Errorf("shard %d was not reconstructed", i)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRSCodeInvalid(t *testing.T) {
+	for _, km := range [][2]int{{0, 1}, {1, 0}, {200, 57}} {
+		if _, err := newRSCode(km[0], km[1]); err == nil {
+			t.Errorf("newRSCode(%d, %d): expected an error", km[0], km[1])
+		}
+	}
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// The interactive shell unlocks a vault once and then runs commands on it,
+// without prompting for the password (and decoding the vault) for each one.
+// Files are named relative to the shell's current directory in the vault.
+
+const (
+	shellPrompt       = "gringotts:/%s> "
+	shellLockedPrompt = "gringotts (locked)> "
+)
+
+// shellCommand is a command understood by the interactive shell.
+type shellCommand struct {
+	name    string
+	args    string
+	summary string
+	run     func(sh *shell, args []string) error
+	// complete selects what the command's arguments are completed to
+	complete func(sh *shell, word string) []string
+}
+
+var shellCommands []*shellCommand
+
+func init() {
+	shellCommands = []*shellCommand{
+		{name: "help", summary: "display this help", run: (*shell).help},
+		{name: "ls", args: "[dir]", summary: "list the files and directories in a directory", run: (*shell).ls, complete: (*shell).completeEntry},
+		{name: "cd", args: "[dir]", summary: "change the current directory (the root if omitted)", run: (*shell).cd, complete: (*shell).completeEntry},
+		{name: "pwd", summary: "display the current directory", run: (*shell).pwd},
+		{name: "get", args: "<name> [output]", summary: "decrypt a file, directory or glob", run: (*shell).get, complete: (*shell).completeEntry},
+		{name: "put", args: "<path>...", summary: "encrypt local files/directories into the current directory", run: (*shell).put, complete: completeLocal},
+		{name: "rm", args: "<name>...", summary: "remove files (or a single version with name@version)", run: (*shell).rm, complete: (*shell).completeEntry},
+		{name: "mv", args: "<name> <new name>", summary: "rename a file or move a directory", run: (*shell).mv, complete: (*shell).completeEntry},
+		{name: "cat", args: "<name>", summary: "display the contents of a file", run: (*shell).cat, complete: (*shell).completeEntry},
+		{name: "verify", summary: "check the ciphertexts in the vault for tampering", run: (*shell).verify},
+		{name: "lock", summary: "lock the vault (it is unlocked by the next command)", run: (*shell).lockCmd},
+		{name: "exit", summary: "save the vault and exit the shell", run: nil},
+	}
+}
+
+func lookupShellCommand(name string) *shellCommand {
+	for _, cmd := range shellCommands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+type shell struct {
+	name string
+	dir  string
+	// v is nil while the vault is locked
+	v         *AESVault
+	cwd       string
+	term      *terminal.Terminal
+	lockAfter time.Duration
+	// mu guards v against the auto-lock while completing a command line,
+	// which is done by the goroutine reading the line
+	mu sync.Mutex
+}
+
+// lineResult is the result of reading a line from the terminal.
+type lineResult struct {
+	line string
+	err  error
+}
+
+// runShell runs the interactive shell on the vault with the given name, which
+// is locked after lockAfter of inactivity (never, if it is 0).
+func runShell(name string, lockAfter time.Duration) error {
+	name, dir, err := resolveVault(name)
+	if err != nil {
+		return err
+	}
+	fd := int(syscall.Stdin)
+	if !terminal.IsTerminal(fd) {
+		return fmt.Errorf("the shell requires a terminal")
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("error setting up terminal: %s", err.Error())
+	}
+	defer terminal.Restore(fd, state)
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	sh := &shell{
+		name:      name,
+		dir:       dir,
+		term:      terminal.NewTerminal(rw, shellLockedPrompt),
+		lockAfter: lockAfter,
+	}
+	if width, height, err := terminal.GetSize(fd); err == nil && width > 0 {
+		sh.term.SetSize(width, height)
+	}
+	sh.term.AutoCompleteCallback = sh.autoComplete
+	if err := sh.unlock(); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.term, "Vault '%s' unlocked, type 'help' for a list of commands.\n", name)
+	for {
+		line, err := sh.readLine(false, "")
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			sh.lock()
+			return fmt.Errorf("error reading command: %s", err.Error())
+		}
+		args, err := splitWords(line)
+		if err != nil {
+			fmt.Fprintf(sh.term, "%s\n", err.Error())
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cmd := lookupShellCommand(args[0])
+		if cmd == nil {
+			fmt.Fprintf(sh.term, "unknown command '%s', type 'help' for a list of commands\n", args[0])
+			continue
+		}
+		if cmd.name == "exit" {
+			break
+		}
+		if sh.v == nil {
+			if err := sh.unlock(); err != nil {
+				fmt.Fprintf(sh.term, "%s\n", err.Error())
+				continue
+			}
+		}
+		if err := cmd.run(sh, args[1:]); err != nil {
+			fmt.Fprintf(sh.term, "%s: %s\n", cmd.name, err.Error())
+		}
+		// save after every command, so that the vault is consistent with its
+		// ciphertexts even if the shell is killed
+		if sh.v == nil {
+			continue
+		}
+		if err := sh.v.Close(); err != nil {
+			fmt.Fprintf(sh.term, "vault save error: %s\n", err.Error())
+		}
+	}
+	return sh.lock()
+}
+
+// readLine reads a line (or password, which is not echoed) from the terminal,
+// locking the vault if nothing is entered for the inactivity timeout.
+// The prompt of a password is given; lines use the shell's prompt.
+func (sh *shell) readLine(password bool, prompt string) (string, error) {
+	read := make(chan lineResult, 1)
+	go func() {
+		var r lineResult
+		if password {
+			r.line, r.err = sh.term.ReadPassword(prompt)
+		} else {
+			r.line, r.err = sh.term.ReadLine()
+		}
+		read <- r
+	}()
+	var timeout <-chan time.Time
+	if sh.v != nil && sh.lockAfter > 0 {
+		timer := time.NewTimer(sh.lockAfter)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case r := <-read:
+			return r.line, r.err
+		case <-timeout:
+			timeout = nil
+			if err := sh.lock(); err != nil {
+				fmt.Fprintf(sh.term, "%s\n", err.Error())
+			}
+			fmt.Fprintf(sh.term, "Vault locked after %s of inactivity.\n", sh.lockAfter)
+		}
+	}
+}
+
+// unlock opens the vault, prompting for the password unless the agent holds
+// the key of the vault.
+func (sh *shell) unlock() error {
+	v, err := unlockVault(sh.name, sh.dir, func(prompt string) ([]byte, error) {
+		pwd, err := sh.readLine(true, prompt)
+		return []byte(pwd), err
+	}, sh.term)
+	if err != nil {
+		return err
+	}
+	sh.v = v
+	sh.setPrompt()
+	return nil
+}
+
+// lock saves the vault and discards its key, until it is unlocked again.
+func (sh *shell) lock() error {
+	if sh.v == nil {
+		return nil
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	err := sh.v.Close()
+	for i := range sh.v.key {
+		sh.v.key[i] = 0
+	}
+	sh.v = nil
+	sh.setPrompt()
+	if err != nil {
+		return fmt.Errorf("vault save error: %s", err.Error())
+	}
+	return nil
+}
+
+func (sh *shell) setPrompt() {
+	if sh.v == nil {
+		sh.term.SetPrompt(shellLockedPrompt)
+	} else {
+		sh.term.SetPrompt(fmt.Sprintf(shellPrompt, sh.cwd))
+	}
+}
+
+// confirm asks the user a yes/no question, returning true only if the answer
+// is yes.
+func (sh *shell) confirm(prompt string) bool {
+	sh.term.SetPrompt(prompt + " [y/N] ")
+	defer sh.setPrompt()
+	answer, err := sh.term.ReadLine()
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// resolve returns the vault path referred to by name, which is relative to
+// the current directory unless it starts with "/".
+func (sh *shell) resolve(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		name = path.Join(sh.cwd, name)
+	}
+	return cleanEntryName(name)
+}
+
+func (sh *shell) help(args []string) error {
+	for _, cmd := range shellCommands {
+		fmt.Fprintf(sh.term, "  %-26s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.summary)
+	}
+	fmt.Fprintf(sh.term, "Names are relative to the current directory, unless they start with \"/\".\n")
+	return nil
+}
+
+func (sh *shell) ls(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("expected at most one directory")
+	}
+	dir := sh.cwd
+	if len(args) == 1 {
+		dir = sh.resolve(args[0])
+		if entry := sh.v.resolveFile(dir); entry != nil {
+			fmt.Fprintf(sh.term, "%s %db\n", path.Base(entry.Filename), entry.FileSize())
+			return nil
+		}
+	}
+	if !sh.v.isDir(dir) {
+		return fmt.Errorf("no such file or directory '%s'", dir)
+	}
+	for _, name := range sh.v.dirChildren(dir) {
+		if strings.HasSuffix(name, "/") {
+			fmt.Fprintf(sh.term, "%s\n", name)
+			continue
+		}
+		_, entry := sh.v.lookupFile(path.Join(dir, name))
+		fmt.Fprintf(sh.term, "%s %db\n", name, entry.FileSize())
+	}
+	return nil
+}
+
+func (sh *shell) cd(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("expected at most one directory")
+	}
+	dir := ""
+	if len(args) == 1 {
+		dir = sh.resolve(args[0])
+	}
+	if !sh.v.isDir(dir) {
+		return fmt.Errorf("no such directory '%s'", dir)
+	}
+	sh.cwd = dir
+	sh.setPrompt()
+	return nil
+}
+
+func (sh *shell) pwd(args []string) error {
+	fmt.Fprintf(sh.term, "/%s\n", sh.cwd)
+	return nil
+}
+
+func (sh *shell) get(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("expected a name and, optionally, the output path")
+	}
+	name, output := sh.resolve(args[0]), ""
+	if len(args) == 2 {
+		output = args[1]
+	}
+	switch {
+	case sh.v.resolveFile(name) != nil:
+		return sh.v.RetrieveFile(name, output)
+	case isGlob(name):
+		matches, err := sh.match(name, "decrypted")
+		if err != nil || matches == nil {
+			return err
+		}
+		return sh.v.RetrieveFiles(matches, output)
+	}
+	return sh.v.RetrieveDir(name, output)
+}
+
+func (sh *shell) put(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected files to encrypt")
+	}
+	for _, p := range args {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		absPath, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		name := cleanEntryName(path.Join(sh.cwd, filepath.Base(absPath)))
+		if info.IsDir() {
+			err = bulkResult(sh.v.addDir(p, name))
+		} else {
+			err = sh.v.addFile(p, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sh *shell) rm(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected files to remove")
+	}
+	for _, arg := range args {
+		name := sh.resolve(arg)
+		names := []string{name}
+		if sh.v.resolveFile(name) == nil && isGlob(name) {
+			matches, err := sh.match(name, "removed")
+			if err != nil {
+				return err
+			}
+			names = nil
+			for _, entry := range matches {
+				names = append(names, entry.Filename)
+			}
+		}
+		for _, name := range names {
+			if err := sh.v.RemoveFile(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// match returns the files matching the glob pattern, once the user confirms
+// that the action should be performed on them.
+func (sh *shell) match(pattern, action string) ([]*AESVaultEntry, error) {
+	matches, err := sh.v.FindFiles(EntryFilter{Pattern: pattern})
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no entries match '%s'", pattern)
+	}
+	fmt.Fprintf(sh.term, "The following files will be %s:\n", action)
+	for _, entry := range matches {
+		fmt.Fprintf(sh.term, "%s\n", entry.Filename)
+	}
+	if !sh.confirm("Continue?") {
+		return nil, nil
+	}
+	return matches, nil
+}
+
+func (sh *shell) mv(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected the name of the file and its new name")
+	}
+	return sh.v.Rename(sh.resolve(args[0]), sh.resolve(args[1]))
+}
+
+func (sh *shell) cat(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the name of a file")
+	}
+	return streamFile(sh.v, sh.resolve(args[0]), sh.term, true)
+}
+
+func (sh *shell) verify(args []string) error {
+	results, err := sh.v.Verify(context.Background(), IntegrityOptions{})
+	if err != nil {
+		return err
+	}
+	report := newIntegrityJSON(results)
+	fmt.Fprintf(sh.term, "%d/%d tests passed\n", report.Passed, report.Total)
+	for _, r := range results {
+		if r.Status != statusPassed {
+			fmt.Fprintf(sh.term, "%s: %s@%d: %s\n", r.Status, r.Entry.Filename, r.Entry.Version, r.Reason)
+		}
+	}
+	if report.Failed != 0 {
+		return fmt.Errorf("%d tests failed", report.Failed)
+	}
+	return nil
+}
+
+func (sh *shell) lockCmd(args []string) error {
+	return sh.lock()
+}
+
+// autoComplete completes the word before the cursor when tab is pressed: the
+// command name for the first word, otherwise as selected by the command.
+// If there are several candidates, they are displayed.
+func (sh *shell) autoComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.v == nil {
+		return "", 0, false
+	}
+	start := strings.LastIndex(line[:pos], " ") + 1
+	word := line[start:pos]
+	var candidates []string
+	if strings.TrimSpace(line[:start]) == "" {
+		for _, cmd := range shellCommands {
+			if strings.HasPrefix(cmd.name, word) {
+				candidates = append(candidates, cmd.name+" ")
+			}
+		}
+	} else {
+		fields := strings.Fields(line[:start])
+		cmd := lookupShellCommand(fields[0])
+		if cmd == nil || cmd.complete == nil {
+			return "", 0, false
+		}
+		candidates = cmd.complete(sh, word)
+	}
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	completion := commonPrefix(candidates)
+	if len(candidates) > 1 && completion == word {
+		fmt.Fprintf(sh.term, "%s\n", strings.Join(candidates, "  "))
+	}
+	return line[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// completeEntry returns the vault paths which word may be completed to;
+// files are followed by a space and directories by "/".
+func (sh *shell) completeEntry(word string) []string {
+	dir, base := "", word
+	if idx := strings.LastIndex(word, "/"); idx != -1 {
+		dir, base = word[:idx+1], word[idx+1:]
+	}
+	var candidates []string
+	for _, name := range sh.v.dirChildren(sh.resolve(dir)) {
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if !strings.HasSuffix(name, "/") {
+			name += " "
+		}
+		candidates = append(candidates, dir+name)
+	}
+	return candidates
+}
+
+// completeLocal returns the local paths which word may be completed to.
+func completeLocal(sh *shell, word string) []string {
+	dir, base := "", word
+	if idx := strings.LastIndex(word, "/"); idx != -1 {
+		dir, base = word[:idx+1], word[idx+1:]
+	}
+	files, err := ioutil.ReadDir(filepath.FromSlash(dir + "."))
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, info := range files {
+		name := info.Name()
+		if !strings.HasPrefix(name, base) || (base == "" && strings.HasPrefix(name, ".")) {
+			continue
+		}
+		if info.IsDir() {
+			name += "/"
+		} else {
+			name += " "
+		}
+		candidates = append(candidates, dir+name)
+	}
+	return candidates
+}
+
+// commonPrefix returns the longest common prefix of the (non-empty) list of
+// strings.
+func commonPrefix(list []string) string {
+	prefix := list[0]
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// splitWords splits a command line into words, which are separated by spaces.
+// Spaces may be included in words by quoting ('...' or "...") or escaping them
+// with a backslash.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
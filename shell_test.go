@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		line  string
+		words []string
+		fail  bool
+	}{
+		{"", nil, false},
+		{"   ", nil, false},
+		{"ls", []string{"ls"}, false},
+		{"  get  a.txt\tb.txt ", []string{"get", "a.txt", "b.txt"}, false},
+		{`get "my file.txt"`, []string{"get", "my file.txt"}, false},
+		{`get 'my "file".txt'`, []string{"get", `my "file".txt`}, false},
+		{`get my\ file.txt`, []string{"get", "my file.txt"}, false},
+		{`get 'a\ b'`, []string{"get", `a\ b`}, false},
+		{`get "a\"b"`, []string{"get", `a"b`}, false},
+		{`get "" x`, []string{"get", "", "x"}, false},
+		{`get a"b c"d`, []string{"get", "ab cd"}, false},
+		{`get "unterminated`, nil, true},
+		{`get trailing\`, nil, true},
+	}
+	for _, tc := range tests {
+		words, err := splitWords(tc.line)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("splitWords(%q): expected an error", tc.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitWords(%q): %s", tc.line, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(words, tc.words) {
+			t.Errorf("splitWords(%q) = %q, want %q", tc.line, words, tc.words)
+		}
+	}
+}
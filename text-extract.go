@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxIndexedSize is the size of the largest file whose text is indexed.
+const maxIndexedSize = 16 << 20
+
+// Minimum and maximum lengths of the terms in the full-text index.
+const (
+	minTermLen = 2
+	maxTermLen = 64
+)
+
+// extractText returns the text contained in data, if it is a text-like file
+// (plain text, markdown, source code etc.) or a PDF document.
+// The second return value is false if no text could be extracted.
+func extractText(data []byte) (string, bool) {
+	contentType := http.DetectContentType(data)
+	switch {
+	case strings.HasPrefix(contentType, "text/"):
+		if !utf8.Valid(data) {
+			return "", false
+		}
+		return string(data), true
+	case contentType == "application/pdf":
+		text := extractPDFText(data)
+		return text, text != ""
+	}
+	return "", false
+}
+
+// looksBinary reports whether sample, the beginning of a file, appears to be
+// binary data rather than text (which would garble a terminal).
+func looksBinary(sample []byte) bool {
+	if len(sample) == 0 {
+		return false
+	}
+	if bytes.IndexByte(sample, 0) != -1 {
+		return true
+	}
+	if !strings.HasPrefix(http.DetectContentType(sample), "text/") {
+		return true
+	}
+	// the sample may end in the middle of a multi-byte character
+	for i := 0; i < utf8.UTFMax && !utf8.Valid(sample); i++ {
+		sample = sample[:len(sample)-1]
+	}
+	return !utf8.Valid(sample)
+}
+
+// extractPDFText extracts the text drawn by the content streams of a PDF
+// document, on a best-effort basis.
+// Only literal strings shown with the text operators (Tj, TJ, ' and ") are
+// extracted, which covers PDFs produced by most word processors but not ones
+// using custom font encodings or containing scanned images.
+func extractPDFText(data []byte) string {
+	var text strings.Builder
+	for {
+		start := bytes.Index(data, []byte("stream"))
+		if start == -1 {
+			break
+		}
+		dict := data[:start]
+		if idx := bytes.LastIndex(dict, []byte("<<")); idx != -1 {
+			dict = dict[idx:]
+		}
+		data = data[start+len("stream"):]
+		// the stream data starts after the end of line following the keyword
+		data = bytes.TrimLeft(data, "\r")
+		data = bytes.TrimPrefix(data, []byte("\n"))
+		end := bytes.Index(data, []byte("endstream"))
+		if end == -1 {
+			break
+		}
+		content := data[:end]
+		data = data[end+len("endstream"):]
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			r, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// streams are often truncated/padded, so read as much as possible
+			content, _ = ioutil.ReadAll(r)
+		}
+		extractPDFStrings(content, &text)
+	}
+	return text.String()
+}
+
+// extractPDFStrings writes the literal strings within the text objects (BT ...
+// ET) of a content stream to text, separated by spaces.
+func extractPDFStrings(content []byte, text *strings.Builder) {
+	inText := false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == 'B' && i+1 < len(content) && content[i+1] == 'T':
+			inText = true
+		case c == 'E' && i+1 < len(content) && content[i+1] == 'T':
+			inText = false
+		case c == '(' && inText:
+			var s []byte
+			depth := 1
+			for i++; i < len(content) && depth > 0; i++ {
+				switch c := content[i]; c {
+				case '\\':
+					if i+1 < len(content) {
+						i++
+						switch content[i] {
+						case 'n', 'r', 't':
+							s = append(s, ' ')
+						default:
+							s = append(s, content[i])
+						}
+					}
+				case '(':
+					depth++
+					s = append(s, c)
+				case ')':
+					if depth--; depth > 0 {
+						s = append(s, c)
+					}
+				default:
+					s = append(s, c)
+				}
+			}
+			i--
+			text.Write(s)
+			text.WriteByte(' ')
+		}
+	}
+}
+
+// tokenize splits text into the distinct, lower-cased terms (runs of letters
+// and digits) which are stored in the full-text index.
+func tokenize(text string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, term := range splitTerms(text) {
+		if len(term) < minTermLen || len(term) > maxTermLen || seen[term] {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// splitTerms splits text into its lower-cased runs of letters and digits.
+func splitTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
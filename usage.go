@@ -1,6 +1,11 @@
 package main
 
-import "fmt"
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
 
 const usageMsg string = `Gringotts is a vault for encrypted files.
 It provides functionality for creating and managing a vault (adding, removing,
@@ -20,59 +25,59 @@ This includes selecting a strong key, storing the key in a safe & secure place
 sharing it with other parties through a secure channel (such as in-person or
 over a secure end-to-end encrypted communication system).
 
-Usage: gringotts --<command> [argument] ...
+Usage: gringotts <command> [options] [arguments]
 
-Available commands are documented below, in order of decreasing precedence.
-This means that if both "--create <some vault>" and "--add <some file>" are
-specified, then ONLY the create command will be executed.
+Commands operating on an existing vault take the option "--vault <vault name>"
+and prompt for the vault's password.
+Options may be given before or after the arguments; arguments following "--"
+are never treated as options.
 
---help
-  Display this help menu.
+Commands:
+%s
+Use "gringotts help <command>" for more information about a command.
 
---create <vault name>
-  Creates a new vault with the specified name.
+Exit codes:
+  0  success
+  1  the command failed
+  2  the command line was invalid
 
---vault <vault name>
-  This specifies the vault that is being operated on.
-  It needs to be specified when using operational commands, which are shown
-  below.
-
---list
-  Lists the files in the vault.
-
---encrypt <filename>
-  Encrypts and adds the specified file to the vault being operated on.
-
---decrypt <filename>
-  If a file with the given name is stored in the vault, this decrypts the file
-  and stores it in the current directory with its original name.
-
---output <filename>
-  This option is used when decrypting a file to override the filename that the
-  decrypted file is saved to.
-  When specified with other commands, it does nothing.
-
---remove <filename>
-  Removes an encrypted file from the vault.
-  Be careful when using this as the file, once deleted, is not recoverable.
-
-The following are vault management commands used for cleanup and integrity
-testing of the ciphertexts.
-
---cleanup
-  Performs a cleanup of the selected vault.
-  This involves removing all ciphertext files which do not correspond to a file
-  entry in the vault (since they have no chance of being decrypted anymore).
-
---prune-entries
-  Performs a cleanup of the vault's internal data.
-  This involves removing all file entries which do not have corresponding
-  ciphertext files in the vault.
-
---check-integrity
-  Checks if any ciphertexts have been tampered with.
-`
+DEPRECATED FLAGS:
+Previous versions of gringotts took commands as flags, for example
+"gringotts --vault secrets --encrypt file.txt".
+These flags are still accepted, along with the options of the corresponding
+commands, but only one command flag may be given at a time.
+%s`
 
 func usage() {
-	fmt.Print(usageMsg)
+	var cmds strings.Builder
+	for _, cmd := range commands {
+		if cmd.hidden {
+			continue
+		}
+		fmt.Fprintf(&cmds, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Printf(usageMsg, cmds.String(), legacyUsage())
+}
+
+// commandUsage displays the detailed help of the command.
+func commandUsage(cmd *command) {
+	fmt.Printf("Usage: gringotts %s", cmd.name)
+	fs, _ := newFlagSet(cmd)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Printf(" [options]")
+	}
+	if cmd.args != "" {
+		fmt.Printf(" %s", cmd.args)
+	}
+	fmt.Printf("\n\n%s\n", cmd.summary)
+	if cmd.help != "" {
+		fmt.Printf("\n%s\n", cmd.help)
+	}
+	if hasFlags {
+		fmt.Printf("\nOptions:\n")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Annotation describes changes to the tags, note and custom metadata of a
+// file entry.
+// Since the entries are stored in the encrypted vault file, so are their
+// annotations.
+type Annotation struct {
+	AddTags    []string
+	RemoveTags []string
+	// Note replaces the note of the entry, if it is non-nil
+	Note *string
+	// Meta sets the custom metadata keys; keys with empty values are deleted
+	Meta map[string]string
+}
+
+// empty reports whether applying the annotation changes nothing.
+func (a Annotation) empty() bool {
+	return len(a.AddTags) == 0 && len(a.RemoveTags) == 0 && a.Note == nil && len(a.Meta) == 0
+}
+
+// apply applies the changes described by the annotation to entry.
+func (a Annotation) apply(entry *AESVaultEntry) {
+	tags := make(map[string]bool)
+	for _, t := range entry.Tags {
+		tags[t] = true
+	}
+	for _, t := range a.AddTags {
+		if t = strings.TrimSpace(t); t != "" {
+			tags[t] = true
+		}
+	}
+	for _, t := range a.RemoveTags {
+		delete(tags, strings.TrimSpace(t))
+	}
+	entry.Tags = nil
+	for t := range tags {
+		entry.Tags = append(entry.Tags, t)
+	}
+	sort.Strings(entry.Tags)
+	if a.Note != nil {
+		entry.Note = *a.Note
+	}
+	for k, val := range a.Meta {
+		if val == "" {
+			delete(entry.Meta, k)
+			continue
+		}
+		if entry.Meta == nil {
+			entry.Meta = make(map[string]string)
+		}
+		entry.Meta[k] = val
+	}
+}
+
+// SetAddAnnotation sets the annotation which is applied to the files
+// subsequently added to the vault.
+// New versions of a file also inherit the annotations of the previous version.
+func (v *AESVault) SetAddAnnotation(a Annotation) {
+	v.annotation = a
+}
+
+// Annotate applies the annotation to the latest version of the file with the
+// given name (or to a specific version, using "name@version").
+func (v *AESVault) Annotate(name string, a Annotation) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	entry := v.resolveFile(name)
+	if entry == nil {
+		return fmt.Errorf("no entry for '%s' in vault", name)
+	}
+	a.apply(entry)
+	return nil
+}
+
+// inheritAnnotations copies the tags, note and custom metadata of the previous
+// version of a file to entry, its new version.
+func inheritAnnotations(entry, previous *AESVaultEntry) {
+	c := previous.clone()
+	entry.Tags, entry.Note, entry.Meta = c.Tags, c.Note, c.Meta
+}
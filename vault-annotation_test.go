@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnnotationApply(t *testing.T) {
+	entry := &AESVaultEntry{Tags: []string{"b", "old"}, Meta: map[string]string{"k": "v", "gone": "x"}}
+	note := "a note"
+	Annotation{
+		AddTags:    []string{" a ", "b", ""},
+		RemoveTags: []string{"old", "missing"},
+		Note:       &note,
+		Meta:       map[string]string{"gone": "", "new": "value"},
+	}.apply(entry)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(entry.Tags, want) {
+		t.Errorf("tags %v, want %v", entry.Tags, want)
+	}
+	if entry.Note != note {
+		t.Errorf("note %q, want %q", entry.Note, note)
+	}
+	if want := map[string]string{"k": "v", "new": "value"}; !reflect.DeepEqual(entry.Meta, want) {
+		t.Errorf("metadata %v, want %v", entry.Meta, want)
+	}
+	// a nil note leaves the note unchanged
+	Annotation{AddTags: []string{"c"}}.apply(entry)
+	if entry.Note != note {
+		t.Errorf("note changed to %q", entry.Note)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	v := newTestVault(t)
+	note := "first"
+	v.SetAddAnnotation(Annotation{AddTags: []string{"added"}, Note: &note})
+	first := addTestFile(t, v, "x", randomData(10))
+	v.SetAddAnnotation(Annotation{})
+	if err := v.Annotate("x", Annotation{AddTags: []string{"tag"}, Meta: map[string]string{"k": "v"}}); err != nil {
+		t.Fatal(err)
+	}
+	// new versions inherit the annotations, without sharing them
+	second := addTestFile(t, v, "x", randomData(20))
+	if err := v.Annotate("x@1", Annotation{RemoveTags: []string{"tag"}, Meta: map[string]string{"k": "old"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Annotate("y", Annotation{}); err == nil {
+		t.Error("missing file annotated")
+	}
+
+	v = reopenTestVault(t, v)
+	first, second = v.resolveFile("x@1"), v.resolveFile("x@2")
+	if want := []string{"added"}; !reflect.DeepEqual(first.Tags, want) || first.Meta["k"] != "old" {
+		t.Errorf("x@1 annotated with %v and %v", first.Tags, first.Meta)
+	}
+	if want := []string{"added", "tag"}; !reflect.DeepEqual(second.Tags, want) || second.Meta["k"] != "v" || second.Note != note {
+		t.Errorf("x@2 annotated with %v, %v and %q", second.Tags, second.Meta, second.Note)
+	}
+}
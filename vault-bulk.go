@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// Bulk operations process many files concurrently, with a bounded number of
+// workers.
+// A failure does not stop the operation; the files which failed are reported
+// once all the others have been processed.
+
+// DefaultWorkers is the default number of files processed concurrently.
+var DefaultWorkers = runtime.NumCPU()
+
+// BulkError is the failure of a file in a bulk operation.
+type BulkError struct {
+	Name string
+	Err  error
+}
+
+// BulkReport is the result of a bulk operation.
+type BulkReport struct {
+	// past participle of the operation (e.g. "added"), used in messages
+	Op        string
+	Succeeded []string
+	Failed    []BulkError
+}
+
+// Err returns an error summarizing the failures of the operation, or nil if
+// all the files were processed successfully.
+func (r *BulkReport) Err() error {
+	switch len(r.Failed) {
+	case 0:
+		return nil
+	case 1:
+		if len(r.Succeeded) == 0 {
+			return fmt.Errorf("'%s': %s", r.Failed[0].Name, r.Failed[0].Err.Error())
+		}
+	}
+	return fmt.Errorf("%d of %d files could not be %s", len(r.Failed), len(r.Failed)+len(r.Succeeded), r.Op)
+}
+
+// runWorkers calls fn for every index in [0, n), using up to workers
+// goroutines.
+func runWorkers(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// addition is a file to be added to the vault.
+type addition struct {
+	src  string
+	name string
+	// err is set if the file cannot be added
+	err error
+}
+
+// AddFiles encrypts the files at the given paths concurrently, using up to
+// workers goroutines, and adds them to the vault.
+// Directories are added recursively if recursive is set: each entry is named by
+// the path of the file relative to the parent of the directory, so that the
+// tree structure (rooted at the base name of the directory) is preserved.
+// The entries are added once all the files have been encrypted, in the order
+// of the paths, so that the versions of files with the same name are
+// deterministic.
+func (v *AESVault) AddFiles(paths []string, recursive bool, workers int) *BulkReport {
+	report := &BulkReport{Op: "added"}
+	if v.readOnly {
+		for _, p := range paths {
+			report.Failed = append(report.Failed, BulkError{Name: p, Err: errReadOnly})
+		}
+		return report
+	}
+	var files []addition
+	for _, p := range paths {
+		files = append(files, v.listAdditions(p, recursive)...)
+	}
+	report.Succeeded, report.Failed = v.addAll(files, workers)
+	return report
+}
+
+// addAll encrypts the files concurrently, using up to workers goroutines, and
+// adds them to the vault in order, returning the sources of the files which
+// were added and the failures.
+func (v *AESVault) addAll(files []addition, workers int) ([]string, []BulkError) {
+	var succeeded []string
+	var failed []BulkError
+	entries := make([]*AESVaultEntry, len(files))
+	runWorkers(len(files), workers, func(i int) {
+		if files[i].err == nil {
+			entries[i], files[i].err = v.encryptFile(files[i].src, files[i].name)
+		}
+	})
+	for i, f := range files {
+		if f.err != nil {
+			failed = append(failed, BulkError{Name: f.src, Err: f.err})
+			continue
+		}
+		v.addVersion(entries[i])
+		v.indexFile(entries[i], f.src)
+		succeeded = append(succeeded, f.src)
+	}
+	return succeeded, failed
+}
+
+// listAdditions returns the files to add for the path p: the file itself, or
+// the files in the directory tree if recursive is set.
+func (v *AESVault) listAdditions(p string, recursive bool) []addition {
+	info, err := os.Stat(p)
+	if err != nil || !info.IsDir() {
+		// errors are reported when the file is encrypted
+		return []addition{{src: p, name: filepath.Base(p)}}
+	}
+	if !recursive {
+		return []addition{{src: p, err: fmt.Errorf("'%s' is a directory", p)}}
+	}
+	absDir, err := filepath.Abs(p)
+	if err != nil {
+		return []addition{{src: p, err: fmt.Errorf("failed to resolve '%s': %s", p, err.Error())}}
+	}
+	return v.treeAdditions(p, cleanEntryName(filepath.Base(absDir)))
+}
+
+// treeAdditions returns the files to add for the directory tree dir, named by
+// their paths relative to dir under the directory root in the vault.
+// Files which are not regular files (symlinks, devices etc.) are skipped, as is
+// the vault directory, if it is in the tree.
+func (v *AESVault) treeAdditions(dir, root string) []addition {
+	vaultDir, vaultErr := os.Stat(v.dirName)
+	var files []addition
+	filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			files = append(files, addition{src: file, err: fmt.Errorf("error reading '%s': %s", file, err.Error())})
+			return nil
+		}
+		if info.IsDir() && vaultErr == nil && os.SameFile(info, vaultDir) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			files = append(files, addition{src: file, err: err})
+			return nil
+		}
+		files = append(files, addition{src: file, name: cleanEntryName(path.Join(root, filepath.ToSlash(rel)))})
+		return nil
+	})
+	return files
+}
+
+// Retrieval is an entry to be decrypted to the file at Output.
+type Retrieval struct {
+	Entry  *AESVaultEntry
+	Output string
+}
+
+// RetrieveAll decrypts the entries of the retrievals concurrently, using up to
+// workers goroutines.
+// Retrievals to the same output file as a previous one fail, since the files
+// would overwrite each other.
+func (v *AESVault) RetrieveAll(retrievals []Retrieval, workers int) *BulkReport {
+	errs := make([]error, len(retrievals))
+	outputs := make(map[string]bool)
+	for i, r := range retrievals {
+		out := filepath.Clean(r.Output)
+		if outputs[out] {
+			errs[i] = fmt.Errorf("another file is also decrypted to '%s'", r.Output)
+		}
+		outputs[out] = true
+	}
+	runWorkers(len(retrievals), workers, func(i int) {
+		if errs[i] != nil {
+			return
+		}
+		r := retrievals[i]
+		if err := os.MkdirAll(filepath.Dir(r.Output), 0777); err != nil {
+			errs[i] = fmt.Errorf("error creating directory for '%s': %s", r.Output, err.Error())
+			return
+		}
+		errs[i] = v.retrieveEntry(r.Entry, r.Output)
+	})
+	report := &BulkReport{Op: "decrypted"}
+	for i, r := range retrievals {
+		if errs[i] != nil {
+			report.Failed = append(report.Failed, BulkError{Name: r.Entry.Filename, Err: errs[i]})
+		} else {
+			report.Succeeded = append(report.Succeeded, r.Entry.Filename)
+		}
+	}
+	return report
+}
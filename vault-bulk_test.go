@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunWorkers(t *testing.T) {
+	for _, workers := range []int{0, 1, 4, 100} {
+		counts := make([]int32, 50)
+		runWorkers(len(counts), workers, func(i int) { atomic.AddInt32(&counts[i], 1) })
+		for i, n := range counts {
+			if n != 1 {
+				t.Errorf("%d workers: index %d processed %d times", workers, i, n)
+			}
+		}
+	}
+	runWorkers(0, 4, func(int) { t.Error("called with no work") })
+}
+
+func TestBulkReportErr(t *testing.T) {
+	failure := BulkError{Name: "a", Err: os.ErrNotExist}
+	for _, test := range []struct {
+		report BulkReport
+		want   string
+	}{
+		{BulkReport{Op: "added", Succeeded: []string{"a"}}, ""},
+		{BulkReport{Op: "added", Failed: []BulkError{failure}}, "'a': file does not exist"},
+		{BulkReport{Op: "added", Succeeded: []string{"b"}, Failed: []BulkError{failure}}, "1 of 2 files could not be added"},
+	} {
+		err := test.report.Err()
+		if (err == nil && test.want != "") || (err != nil && err.Error() != test.want) {
+			t.Errorf("got error %v, want %q", err, test.want)
+		}
+	}
+}
+
+func TestAddFiles(t *testing.T) {
+	v := newTestVault(t)
+	root := t.TempDir()
+	files := map[string][]byte{
+		"docs/a.txt":       randomData(10),
+		"docs/sub/b.txt":   randomData(20),
+		"docs/sub/c/d.txt": randomData(30),
+	}
+	for name, data := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(root, "docs", "link")); err != nil {
+		t.Fatal(err)
+	}
+	single := writeTestFile(t, "single", randomData(40))
+	docs := filepath.Join(root, "docs")
+	report := v.AddFiles([]string{docs, single, single, filepath.Join(root, "missing")}, true, 4)
+	if len(report.Succeeded) != 5 || len(report.Failed) != 1 || report.Failed[0].Name != filepath.Join(root, "missing") {
+		t.Fatalf("%d files added, %d failed", len(report.Succeeded), len(report.Failed))
+	}
+	for name, data := range files {
+		entry := v.resolveFile(name)
+		if entry == nil {
+			t.Errorf("'%s' not added", name)
+		} else if got := readTestEntry(t, v, entry); !bytes.Equal(got, data) {
+			t.Errorf("'%s' has the wrong contents", name)
+		}
+	}
+	if v.resolveFile("docs/link") != nil {
+		t.Error("symlink added")
+	}
+	if history, _ := v.History("single"); len(history) != 2 {
+		t.Errorf("%d versions of a file added twice", len(history))
+	}
+
+	report = v.AddFiles([]string{docs}, false, 4)
+	if len(report.Failed) != 1 || len(report.Succeeded) != 0 {
+		t.Error("directory added without recursion")
+	}
+}
+
+func TestAddFilesSkipsVault(t *testing.T) {
+	v := newTestVault(t)
+	addTestFile(t, v, "x", randomData(10))
+	// the vault directory is in the tree being added
+	parent := filepath.Dir(v.dirName)
+	if err := ioutil.WriteFile(filepath.Join(parent, "file"), randomData(20), 0600); err != nil {
+		t.Fatal(err)
+	}
+	report := v.AddFiles([]string{parent}, true, 2)
+	if err := report.Err(); err != nil || len(report.Succeeded) != 1 {
+		t.Fatalf("%d files added (%v), want 1", len(report.Succeeded), err)
+	}
+}
+
+func TestRetrieveAll(t *testing.T) {
+	v := newTestVault(t)
+	var retrievals []Retrieval
+	out := t.TempDir()
+	for i, name := range []string{"a", "dir/b", "dir/sub/c"} {
+		entry := addTestFile(t, v, name, randomData(100+i))
+		retrievals = append(retrievals, Retrieval{Entry: entry, Output: filepath.Join(out, filepath.FromSlash(name))})
+	}
+	// a second retrieval to the same output fails, rather than racing
+	retrievals = append(retrievals, Retrieval{Entry: retrievals[0].Entry, Output: retrievals[1].Output + "/../b"})
+	report := v.RetrieveAll(retrievals, 3)
+	if len(report.Succeeded) != 3 || len(report.Failed) != 1 {
+		t.Fatalf("%d files decrypted, %d failed", len(report.Succeeded), len(report.Failed))
+	}
+	for _, r := range retrievals[:3] {
+		data, err := ioutil.ReadFile(r.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, randomData(len(data))) || int64(len(data)) != r.Entry.Size {
+			t.Errorf("'%s' has the wrong contents", r.Output)
+		}
+	}
+}
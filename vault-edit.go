@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// EditFile decrypts the file with the given name to a private temporary file
+// and calls edit with its path.
+// If the file was modified once edit returns, it is encrypted and added to the
+// vault as a new version of the file, keeping the metadata of the edited
+// version (except for its modification time).
+// The temporary file (and any other files created next to it, such as the
+// editor's backups) is overwritten before being removed.
+// It returns whether a new version was added.
+func (v *AESVault) EditFile(name string, edit func(path string) error) (bool, error) {
+	if v.readOnly {
+		return false, errReadOnly
+	}
+	entry := v.resolveFile(name)
+	if entry == nil {
+		return false, fmt.Errorf("no entry for '%s' in vault", name)
+	}
+	dir, err := privateTempDir()
+	if err != nil {
+		return false, err
+	}
+	defer shredDir(dir)
+	tmpPath := filepath.Join(dir, path.Base(entry.Filename))
+	before, err := v.decryptPrivate(entry, tmpPath)
+	if err != nil {
+		return false, err
+	}
+	if err := edit(tmpPath); err != nil {
+		return false, err
+	}
+	after, err := fileChecksum(tmpPath)
+	if err != nil {
+		return false, fmt.Errorf("error reading edited file: %s", err.Error())
+	}
+	if bytes.Equal(before, after) {
+		return false, nil
+	}
+	if err := v.addFile(tmpPath, entry.Filename); err != nil {
+		return false, err
+	}
+	// the metadata of the temporary file is not that of the edited file
+	_, added := v.lookupFile(entry.Filename)
+	edited := entry.clone()
+	added.Mode, added.ModeSet = edited.Mode, edited.ModeSet
+	added.Owner, added.Xattrs = edited.Owner, edited.Xattrs
+	added.ModTime = time.Now()
+	return true, nil
+}
+
+// decryptPrivate decrypts entry to a new file at dst which is only accessible
+// by the user, returning the checksum of the plaintext.
+func (v *AESVault) decryptPrivate(entry *AESVaultEntry, dst string) ([]byte, error) {
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %s", err.Error())
+	}
+	sum := sha256.New()
+	err = v.readEntry(entry, io.MultiWriter(f, sum))
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting '%s': %s", entry.Filename, err.Error())
+	}
+	return sum.Sum(nil), nil
+}
+
+// privateTempDir creates a temporary directory which is only accessible by the
+// user, preferring a memory-backed file system (so that plaintext is never
+// written to disk) where one is available.
+func privateTempDir() (string, error) {
+	base := ""
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		base = "/dev/shm"
+	}
+	dir, err := ioutil.TempDir(base, "gringotts-")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary directory: %s", err.Error())
+	}
+	// TempDir already creates the directory with mode 0700 (before umask)
+	if err := os.Chmod(dir, 0700); err != nil {
+		os.Remove(dir)
+		return "", fmt.Errorf("error securing temporary directory: %s", err.Error())
+	}
+	return dir, nil
+}
+
+// shredDir overwrites the regular files in dir with zeros and removes it.
+func shredDir(dir string) error {
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			shredFile(p, info.Size())
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
+
+// shredFile overwrites the first size bytes of the file at p with zeros.
+// On file systems which do not write in place (copy-on-write, journaling data
+// etc.) the original data may survive, which is why plaintext is preferably
+// written to a memory-backed file system.
+func shredFile(p string, size int64) error {
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zeros := make([]byte, 32*1024)
+	for written := int64(0); written < size; {
+		n := int64(len(zeros))
+		if size-written < n {
+			n = size - written
+		}
+		if _, err := f.Write(zeros[:n]); err != nil {
+			return err
+		}
+		written += n
+	}
+	return f.Sync()
+}
+
+// fileChecksum returns the SHA-256 checksum of the contents of the file at p.
+func fileChecksum(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return nil, err
+	}
+	return sum.Sum(nil), nil
+}
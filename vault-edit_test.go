@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// editTestEntry edits the file called name in the vault, appending data to it,
+// and returns the mode of the new version once it is retrieved.
+func editTestEntry(t *testing.T, v *AESVault, name string, data []byte) os.FileMode {
+	t.Helper()
+	changed, err := v.EditFile(name, func(p string) error {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("the edit did not add a version")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	if err := v.retrieveEntry(v.resolveFile(name), out); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestEditFile(t *testing.T) {
+	v := newTestVault(t)
+	old := randomData(100)
+	entry := addTestFile(t, v, "x", old)
+	entry.Mode = 0640
+	entry.Tags = []string{"tag"}
+	if mode := editTestEntry(t, v, "x", []byte("more")); mode != 0640 {
+		t.Errorf("edited file restored with mode %#o, want 0640", mode)
+	}
+	added := v.resolveFile("x")
+	if added.Version != 2 || len(added.Tags) != 1 {
+		t.Errorf("edit added version %d with tags %v", added.Version, added.Tags)
+	}
+	if got := readTestEntry(t, v, added); !bytes.Equal(got, append(old, "more"...)) {
+		t.Error("the edited file has the wrong contents")
+	}
+
+	changed, err := v.EditFile("x", func(string) error { return nil })
+	if err != nil || changed {
+		t.Errorf("unchanged file added as a new version (%v)", err)
+	}
+	if _, err := v.EditFile("y", func(string) error { return nil }); err == nil {
+		t.Error("missing file edited")
+	}
+}
+
+func TestEditFileWithoutMode(t *testing.T) {
+	v := newTestVault(t)
+	// entries added by earlier versions only recorded non-zero modes
+	entry := addTestFile(t, v, "x", randomData(100))
+	entry.Mode, entry.ModeSet = 0, false
+	if mode := editTestEntry(t, v, "x", []byte("more")); mode != 0600 {
+		t.Errorf("edited file restored with mode %#o, want 0600", mode)
+	}
+	if added := v.resolveFile("x"); added.hasMode() {
+		t.Errorf("edited file has mode %v recorded", added.Mode)
+	}
+}
@@ -18,8 +18,20 @@ var vaultIV []byte = []byte{
 	0xa1, 0xae, 0x35, 0xec, 0x50, 0x4e, 0x74, 0xdc,
 }
 
-// encodeToFile encodes the Vault structure to disk.
+// encodeToFile encodes the Vault structure to disk, as the next generation of
+// the vault file, which is then recorded in the params file.
+// If the vault file is older than the generation recorded in the params file,
+// it is saved without changing its generation or the params file, so that it
+// is still reported by Fsck.
 func (v *AESVault) encodeToFile() error {
+	params, err := readParams(v.dirName)
+	if err != nil {
+		return err
+	}
+	stale := params.Generation > v.Generation
+	if !stale {
+		v.Generation++
+	}
 	saveFileName := v.dirName + vaultFile
 	f, err := os.OpenFile(saveFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
@@ -51,7 +63,17 @@ func (v *AESVault) encodeToFile() error {
 	if n, err := f.Write(buff.Bytes()); err != nil {
 		return fmt.Errorf("error writing vault (%d bytes/%d bytes): %s", n, buffLen, err.Error())
 	}
-	return nil
+	if stale {
+		return nil
+	}
+	return v.recordGeneration()
+}
+
+// recordGeneration records the generation of the vault file in the params
+// file.
+func (v *AESVault) recordGeneration() error {
+	v.params.Generation = v.Generation
+	return writeParams(v.dirName, v.params)
 }
 
 // decodeFromFile decodes the Vault structure from the disk to an in-memory,
@@ -1,11 +1,9 @@
 package main
 
-type VaultEntry interface {
-	// original name of the file
-	Name() string
-	// size of the file
-	FileSize() int64
-}
+import (
+	"os"
+	"time"
+)
 
 type AESVaultEntry struct {
 	Filename      string
@@ -14,7 +12,76 @@ type AESVaultEntry struct {
 	Size          int64
 	Padding       int64
 	HMAC          []byte
+	// Checksum is the SHA-256 checksum of the plaintext, used to check that the
+	// entry decrypts to the original file (not recorded by earlier versions)
+	Checksum []byte
+	// Parity describes the parity data of the ciphertext, if it has any
+	Parity *ParityInfo
+	// Verified records the last integrity test of the ciphertext, if any
+	Verified *VerifyRecord
+	// version of the file (starting at 1) and the time it was added at
+	Version int
+	Added   time.Time
+	// user-defined annotations, used to organize and find files
+	Tags []string
+	Note string
+	Meta map[string]string
+	// metadata of the original file, restored on retrieval
+	Mode os.FileMode
+	// ModeSet is set if Mode was recorded (Mode may be 0)
+	ModeSet bool
+	ModTime time.Time
+	Owner   *FileOwner
+	Xattrs  map[string][]byte
+}
+
+// FileOwner records the numeric owner and group of a file.
+type FileOwner struct {
+	Uid int
+	Gid int
 }
 
-func (e *AESVaultEntry) Name() string    { return e.Filename }
+// Name returns the original name of the file.
+func (e *AESVaultEntry) Name() string { return e.Filename }
+
+// FileSize returns the size of the file.
 func (e *AESVaultEntry) FileSize() int64 { return e.Size }
+
+// hasMode reports whether the mode of the original file was recorded.
+// Entries added by earlier versions only record non-zero modes.
+func (e *AESVaultEntry) hasMode() bool { return e.ModeSet || e.Mode != 0 }
+
+// clone returns a deep copy of the entry.
+func (e *AESVaultEntry) clone() *AESVaultEntry {
+	c := *e
+	c.IV = append([]byte(nil), e.IV...)
+	c.HMAC = append([]byte(nil), e.HMAC...)
+	c.Checksum = append([]byte(nil), e.Checksum...)
+	if e.Parity != nil {
+		parity := *e.Parity
+		parity.Checksums = append([]uint32(nil), e.Parity.Checksums...)
+		c.Parity = &parity
+	}
+	if e.Verified != nil {
+		verified := *e.Verified
+		c.Verified = &verified
+	}
+	if e.Owner != nil {
+		owner := *e.Owner
+		c.Owner = &owner
+	}
+	if e.Xattrs != nil {
+		c.Xattrs = make(map[string][]byte, len(e.Xattrs))
+		for k, val := range e.Xattrs {
+			c.Xattrs[k] = append([]byte(nil), val...)
+		}
+	}
+	c.Tags = append([]string(nil), e.Tags...)
+	if e.Meta != nil {
+		c.Meta = make(map[string]string, len(e.Meta))
+		for k, val := range e.Meta {
+			c.Meta[k] = val
+		}
+	}
+	return &c
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EntryFilter selects file entries based on their names, sizes, dates and
+// annotations.
+// The zero value matches every entry.
+type EntryFilter struct {
+	// Pattern is a glob (see path.Match) which the full name of the entry must
+	// match; note that '*' does not match '/'
+	Pattern string
+	// Regexp must match (part of) the full name of the entry, if non-nil
+	Regexp *regexp.Regexp
+	// bounds (inclusive) on the size of the file; 0 means unbounded
+	MinSize int64
+	MaxSize int64
+	// bounds on the time the entry was added; the zero time means unbounded
+	Since time.Time
+	Until time.Time
+	// Tags which the entry must have (all of them)
+	Tags []string
+	// Meta holds the custom metadata values which the entry must have
+	Meta map[string]string
+}
+
+// isGlob reports whether s contains any glob metacharacters.
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[\\")
+}
+
+// Matches reports whether entry is selected by the filter.
+// The filter's pattern is assumed to be well-formed.
+func (f EntryFilter) Matches(entry *AESVaultEntry) bool {
+	if f.Pattern != "" {
+		if ok, _ := path.Match(f.Pattern, entry.Filename); !ok {
+			return false
+		}
+	}
+	if f.Regexp != nil && !f.Regexp.MatchString(entry.Filename) {
+		return false
+	}
+	if (f.MinSize != 0 && entry.Size < f.MinSize) || (f.MaxSize != 0 && entry.Size > f.MaxSize) {
+		return false
+	}
+	if (!f.Since.IsZero() && entry.Added.Before(f.Since)) || (!f.Until.IsZero() && entry.Added.After(f.Until)) {
+		return false
+	}
+	for _, t := range f.Tags {
+		found := false
+		for _, et := range entry.Tags {
+			if et == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, val := range f.Meta {
+		if ev, ok := entry.Meta[k]; !ok || ev != val {
+			return false
+		}
+	}
+	return true
+}
+
+// FindFiles returns the latest version of each file in the vault which is
+// selected by the filter.
+func (v *AESVault) FindFiles(f EntryFilter) ([]*AESVaultEntry, error) {
+	if _, err := path.Match(f.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %s", f.Pattern, err.Error())
+	}
+	var entries []*AESVaultEntry
+	for _, entry := range v.latestEntries() {
+		if f.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// SortEntries sorts entries by the given key, which is one of "name", "size"
+// or "date" (the time the entry was added).
+// Entries with equal keys are ordered by name.
+func SortEntries(entries []*AESVaultEntry, key string, reverse bool) error {
+	var less func(a, b *AESVaultEntry) bool
+	switch key {
+	case "name":
+		less = func(a, b *AESVaultEntry) bool { return false }
+	case "size":
+		less = func(a, b *AESVaultEntry) bool { return a.Size < b.Size }
+	case "date":
+		less = func(a, b *AESVaultEntry) bool { return a.Added.Before(b.Added) }
+	default:
+		return fmt.Errorf("unknown sort key '%s'", key)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if reverse {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		} else if less(b, a) {
+			return false
+		}
+		return a.Filename < b.Filename
+	})
+	return nil
+}
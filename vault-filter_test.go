@@ -0,0 +1,87 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFindFiles(t *testing.T) {
+	v := newTestVault(t)
+	addTestFile(t, v, "docs/a.txt", randomData(10))
+	addTestFile(t, v, "docs/b.pdf", randomData(2000))
+	addTestFile(t, v, "docs/sub/c.txt", randomData(300))
+	addTestFile(t, v, "d.txt", randomData(40))
+	v.Annotate("docs/a.txt", Annotation{AddTags: []string{"tax", "2020"}, Meta: map[string]string{"owner": "me"}})
+	v.Annotate("d.txt", Annotation{AddTags: []string{"tax"}})
+	// only the latest version is matched
+	addTestFile(t, v, "d.txt", randomData(5000))
+
+	now := time.Now()
+	for _, test := range []struct {
+		filter EntryFilter
+		want   []string
+	}{
+		{EntryFilter{}, []string{"docs/a.txt", "docs/b.pdf", "docs/sub/c.txt", "d.txt"}},
+		{EntryFilter{Pattern: "docs/*.txt"}, []string{"docs/a.txt"}},
+		{EntryFilter{Pattern: "*.txt"}, []string{"d.txt"}},
+		{EntryFilter{Regexp: regexp.MustCompile(`\.txt$`)}, []string{"docs/a.txt", "docs/sub/c.txt", "d.txt"}},
+		{EntryFilter{MinSize: 300, MaxSize: 2000}, []string{"docs/b.pdf", "docs/sub/c.txt"}},
+		{EntryFilter{MinSize: 4000}, []string{"d.txt"}},
+		{EntryFilter{Tags: []string{"tax"}}, []string{"docs/a.txt", "d.txt"}},
+		{EntryFilter{Tags: []string{"tax", "2020"}}, []string{"docs/a.txt"}},
+		{EntryFilter{Meta: map[string]string{"owner": "me"}}, []string{"docs/a.txt"}},
+		{EntryFilter{Meta: map[string]string{"owner": "you"}}, nil},
+		{EntryFilter{Since: now.Add(-time.Hour), Until: now.Add(time.Hour)}, []string{"docs/a.txt", "docs/b.pdf", "docs/sub/c.txt", "d.txt"}},
+		{EntryFilter{Since: now.Add(time.Hour)}, nil},
+	} {
+		entries, err := v.FindFiles(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Filename)
+		}
+		if !equalStrings(names, test.want) {
+			t.Errorf("filter %+v matched %v, want %v", test.filter, names, test.want)
+		}
+	}
+	if _, err := v.FindFiles(EntryFilter{Pattern: "["}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	now := time.Now()
+	entries := []*AESVaultEntry{
+		{Filename: "b", Size: 10, Added: now},
+		{Filename: "a", Size: 20, Added: now.Add(time.Second)},
+		{Filename: "c", Size: 10, Added: now.Add(-time.Second)},
+	}
+	for _, test := range []struct {
+		key     string
+		reverse bool
+		want    []string
+	}{
+		{"name", false, []string{"a", "b", "c"}},
+		{"name", true, []string{"c", "b", "a"}},
+		{"size", false, []string{"b", "c", "a"}},
+		{"size", true, []string{"a", "c", "b"}},
+		{"date", false, []string{"c", "b", "a"}},
+	} {
+		if err := SortEntries(entries, test.key, test.reverse); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Filename)
+		}
+		if !equalStrings(names, test.want) {
+			t.Errorf("sorted by %s (reverse %v): %v, want %v", test.key, test.reverse, names, test.want)
+		}
+	}
+	if err := SortEntries(entries, "color", false); err == nil {
+		t.Error("unknown sort key accepted")
+	}
+}
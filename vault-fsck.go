@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Fsck cross-checks the vault file against the vault directory.
+// Each problem it finds belongs to a class, and can be fixed if the fix cannot
+// lose data: entries without ciphertexts are removed, orphan ciphertexts are
+// moved to the trash, files which do not belong in the vault directory (and
+// orphan ciphertexts, if the trash is disabled or the vault file is an older
+// copy) are moved out of it, and entries are only corrected if the correction
+// can be verified.
+
+// Classes of problems found by Fsck, in the order in which they are fixed.
+const (
+	// files or directories in the vault directory which are neither
+	// ciphertexts nor vault files
+	fsckUnexpected = "unexpected"
+	// several entries for the same version of a file (or invalid versions)
+	fsckDuplicateName = "duplicate-name"
+	// several entries (of different files or versions) sharing a ciphertext
+	fsckDuplicateCiphertext = "duplicate-ciphertext"
+	// entries whose size and padding do not match their ciphertext
+	fsckSize = "size"
+	// entries whose ciphertext is missing
+	fsckMissing = "missing"
+	// ciphertexts which do not correspond to any entry
+	fsckOrphan = "orphan"
+	// a full-text index or vault file inconsistent with the entries and
+	// directory
+	fsckIndex = "index"
+)
+
+var fsckClasses = []string{
+	fsckUnexpected,
+	fsckDuplicateName,
+	fsckDuplicateCiphertext,
+	fsckSize,
+	fsckMissing,
+	fsckOrphan,
+	fsckIndex,
+}
+
+// FsckProblem is an inconsistency between the vault file and the vault
+// directory.
+type FsckProblem struct {
+	Class string
+	// Name is the entry ("name@version") or the file in the vault directory
+	// which has the problem
+	Name   string
+	Detail string
+	// fix fixes the problem; nil if it cannot be fixed safely
+	fix   func() error
+	Fixed bool
+	// FixErr is set if the problem could not be fixed
+	FixErr error
+}
+
+// Fixable reports whether the problem can be fixed safely.
+func (p *FsckProblem) Fixable() bool { return p.fix != nil }
+
+// lostAndFound returns the directory to which Fsck moves the unexpected files
+// (and, if the trash is disabled, the orphan ciphertexts) of the vault
+// directory.
+func (v *AESVault) lostAndFound() string {
+	return filepath.Clean(v.dirName) + ".lost+found"
+}
+
+// Fsck checks the vault for problems, returning them grouped by class (in the
+// order of fsckClasses).
+func (v *AESVault) Fsck() ([]*FsckProblem, error) {
+	dirContents, err := ioutil.ReadDir(v.dirName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vault contents: %s", err.Error())
+	}
+	params, err := readParams(v.dirName)
+	if err != nil {
+		return nil, err
+	}
+	// an older copy of the vault file does not reference the ciphertexts
+	// added since, so they must not expire with the trash
+	olderCopy := params.Generation > v.Generation
+	files := make(map[string]os.FileInfo)
+	for _, f := range dirContents {
+		files[f.Name()] = f
+	}
+	refs := v.ciphertextRefs()
+	var problems []*FsckProblem
+	add := func(class, name, detail string, fix func() error) {
+		problems = append(problems, &FsckProblem{Class: class, Name: name, Detail: detail, fix: fix})
+	}
+
+	// unexpected files and orphan ciphertexts
+	for _, f := range dirContents {
+		name := f.Name()
+		if "/"+name == vaultFile || name == paramsFile || refs[name] {
+			continue
+		}
+		switch {
+		case f.IsDir():
+			add(fsckUnexpected, name, "subdirectory in the vault directory", v.moveToLostAndFound(name))
+		case strings.HasPrefix(name, ".parity-") || strings.HasPrefix(name, ".repair-"):
+			p := filepath.Join(v.dirName, name)
+			add(fsckUnexpected, name, "temporary file left by an interrupted command", func() error {
+				return os.Remove(p)
+			})
+		case !isCiphertextName(strings.TrimSuffix(name, paritySuffix)):
+			add(fsckUnexpected, name, "file which is not a ciphertext", v.moveToLostAndFound(name))
+		case olderCopy:
+			add(fsckOrphan, name, "ciphertext which does not correspond to any entry of the older vault file", v.moveToLostAndFound(name))
+		default:
+			add(fsckOrphan, name, "ciphertext which does not correspond to any entry", v.trashOrphan(name))
+		}
+	}
+
+	// duplicate versions
+	versions := make(map[string][]*AESVaultEntry)
+	for _, entry := range v.Files {
+		ref := fmt.Sprintf("%s@%d", entry.Filename, entry.Version)
+		versions[ref] = append(versions[ref], entry)
+		if entry.Version < 1 {
+			add(fsckDuplicateName, ref, "invalid version number", v.renumberVersions(entry.Filename))
+		}
+	}
+	var refsList []string
+	for ref, entries := range versions {
+		if len(entries) > 1 {
+			refsList = append(refsList, ref)
+		}
+	}
+	sort.Strings(refsList)
+	for _, ref := range refsList {
+		entries := versions[ref]
+		add(fsckDuplicateName, ref, fmt.Sprintf("%d entries for the same version", len(entries)), v.renumberVersions(entries[0].Filename))
+	}
+
+	// shared ciphertexts
+	owners := make(map[string]*AESVaultEntry)
+	for _, entry := range v.Files {
+		owner, ok := owners[entry.EncryptedName]
+		if !ok {
+			owners[entry.EncryptedName] = entry
+			continue
+		}
+		if owner.Filename == entry.Filename && owner.Version == entry.Version {
+			// a duplicate entry, fixed with the duplicate versions
+			continue
+		}
+		add(fsckDuplicateCiphertext, fmt.Sprintf("%s@%d", entry.Filename, entry.Version),
+			fmt.Sprintf("shares its ciphertext with %s@%d", owner.Filename, owner.Version), v.copyCiphertext(entry))
+	}
+
+	// missing ciphertexts and sizes
+	for _, entry := range v.Files {
+		ref := fmt.Sprintf("%s@%d", entry.Filename, entry.Version)
+		f, ok := files[filepath.Base(v.ciphertextPath(entry))]
+		if !ok {
+			add(fsckMissing, ref, "the ciphertext is missing", v.dropEntry(entry))
+			continue
+		}
+		if entry.Padding < 0 || entry.Padding >= AES_BS || (entry.Size+entry.Padding)%AES_BS != 0 {
+			add(fsckSize, ref, fmt.Sprintf("invalid padding %d for a file of %d bytes", entry.Padding, entry.Size), v.fixSize(entry, f.Size()))
+		} else if f.Size() != entry.Size+entry.Padding {
+			add(fsckSize, ref, fmt.Sprintf("ciphertext size %d does not match the file size %d and padding %d", f.Size(), entry.Size, entry.Padding), v.fixSize(entry, f.Size()))
+		}
+	}
+
+	// missing ciphertexts of the trash and snapshots, and missing parity files
+	for _, item := range v.Trash {
+		if item.Entry == nil || files[item.Entry.EncryptedName] != nil {
+			continue
+		}
+		missing := item
+		add(fsckMissing, item.Name(), "the ciphertext of the entry in the trash is missing", func() error {
+			v.dropTrashItem(missing)
+			return nil
+		})
+	}
+	for _, snapshot := range v.Snapshots {
+		for _, entry := range snapshot.Files {
+			if files[entry.EncryptedName] == nil {
+				add(fsckMissing, fmt.Sprintf("%s@%d", entry.Filename, entry.Version),
+					fmt.Sprintf("the ciphertext of the entry in snapshot '%s' is missing", snapshot.Label), nil)
+			}
+		}
+	}
+	for _, entry := range v.parityEntries() {
+		if files[entry.Parity.File] == nil && files[entry.EncryptedName] != nil {
+			add(fsckMissing, entry.Parity.File, fmt.Sprintf("the parity file of %s@%d is missing", entry.Filename, entry.Version), v.rewriteParity(entry))
+		}
+	}
+
+	// the vault file and full-text index
+	switch {
+	case olderCopy:
+		add(fsckIndex, strings.TrimPrefix(vaultFile, "/"), fmt.Sprintf("the vault file is generation %d, but generation %d was saved; it may have been restored from an older copy", v.Generation, params.Generation), v.recordGeneration)
+	case params.Generation != 0 && params.Generation < v.Generation:
+		add(fsckIndex, paramsFile, fmt.Sprintf("the params file records generation %d of the vault file, which is generation %d", params.Generation, v.Generation), v.recordGeneration)
+	}
+	if !v.FullText && v.TextIndex != nil {
+		add(fsckIndex, "fulltext", "the full-text index is disabled, but the vault has one", func() error {
+			v.TextIndex = nil
+			return nil
+		})
+	}
+	stale := make(map[string]bool)
+	for _, postings := range v.TextIndex {
+		for _, p := range postings {
+			if !refs[p] {
+				stale[p] = true
+			}
+		}
+	}
+	if len(stale) != 0 {
+		add(fsckIndex, "fulltext", fmt.Sprintf("the full-text index refers to %d deleted ciphertexts", len(stale)), func() error {
+			v.unindex(stale)
+			return nil
+		})
+	}
+
+	order := make(map[string]int)
+	for i, class := range fsckClasses {
+		order[class] = i
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return order[problems[i].Class] < order[problems[j].Class]
+	})
+	return problems, nil
+}
+
+// FsckFix fixes the problems of the given classes which can be fixed safely.
+// The results are recorded in the problems.
+func (v *AESVault) FsckFix(problems []*FsckProblem, classes map[string]bool) {
+	for _, p := range problems {
+		if !classes[p.Class] || p.fix == nil {
+			continue
+		}
+		if err := p.fix(); err != nil {
+			p.FixErr = err
+		} else {
+			p.Fixed = true
+		}
+	}
+}
+
+// isCiphertextName reports whether name is the name of a ciphertext (see
+// createCiphertext).
+func isCiphertextName(name string) bool {
+	id, err := base64.URLEncoding.DecodeString(name)
+	return err == nil && len(id) == sha256.Size
+}
+
+// moveToLostAndFound returns a fix moving the file with the given name from the
+// vault directory to the lost+found directory.
+func (v *AESVault) moveToLostAndFound(name string) func() error {
+	return func() error {
+		dir := v.lostAndFound()
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("error creating '%s': %s", dir, err.Error())
+		}
+		dst := filepath.Join(dir, name)
+		if _, err := os.Lstat(dst); err == nil {
+			return fmt.Errorf("'%s' already exists", dst)
+		}
+		return os.Rename(filepath.Join(v.dirName, name), dst)
+	}
+}
+
+// trashOrphan returns a fix moving the orphan ciphertext with the given name to
+// the trash or, if the trash is disabled, to the lost+found directory.
+func (v *AESVault) trashOrphan(name string) func() error {
+	return func() error {
+		if v.TrashDays == 0 {
+			return v.moveToLostAndFound(name)()
+		}
+		v.DeleteCiphertexts([]string{name})
+		return nil
+	}
+}
+
+// renumberVersions returns a fix for the duplicate (or invalid) versions of the
+// file with the given name: duplicate entries for the same ciphertext are
+// dropped, and the versions are renumbered in order, changing as few of them
+// as possible.
+func (v *AESVault) renumberVersions(name string) func() error {
+	return func() error {
+		seen := make(map[string]bool)
+		var kept, versions []*AESVaultEntry
+		for _, entry := range v.Files {
+			if entry.Filename == name {
+				key := fmt.Sprintf("%d/%s", entry.Version, entry.EncryptedName)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				versions = append(versions, entry)
+			}
+			kept = append(kept, entry)
+		}
+		v.Files = kept
+		sort.SliceStable(versions, func(i, j int) bool {
+			if versions[i].Version != versions[j].Version {
+				return versions[i].Version < versions[j].Version
+			}
+			return versions[i].Added.Before(versions[j].Added)
+		})
+		// the versions in the trash and snapshots are taken
+		taken := make(map[int]bool)
+		for _, item := range v.Trash {
+			if item.Entry != nil && item.Entry.Filename == name {
+				taken[item.Entry.Version] = true
+			}
+		}
+		for _, snapshot := range v.Snapshots {
+			for _, entry := range snapshot.Files {
+				if entry.Filename == name {
+					taken[entry.Version] = true
+				}
+			}
+		}
+		last := 0
+		for _, entry := range versions {
+			if entry.Version <= last {
+				entry.Version = last + 1
+				for taken[entry.Version] {
+					entry.Version++
+				}
+			}
+			last = entry.Version
+		}
+		return nil
+	}
+}
+
+// copyCiphertext returns a fix giving entry its own copy of its (shared)
+// ciphertext.
+func (v *AESVault) copyCiphertext(entry *AESVaultEntry) func() error {
+	return func() error {
+		src, err := os.Open(v.ciphertextPath(entry))
+		if err != nil {
+			return fmt.Errorf("error opening ciphertext file: %s", err.Error())
+		}
+		defer src.Close()
+		dst, err := v.createCiphertext()
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+		if _, err := io.Copy(dst, src); err != nil {
+			os.Remove(dst.Name())
+			return fmt.Errorf("error copying ciphertext: %s", err.Error())
+		}
+		if err := dst.Close(); err != nil {
+			os.Remove(dst.Name())
+			return fmt.Errorf("error copying ciphertext: %s", err.Error())
+		}
+		shared := entry.EncryptedName
+		entry.EncryptedName = filepath.Base(dst.Name())
+		for term, postings := range v.TextIndex {
+			for _, p := range postings {
+				if p == shared {
+					v.TextIndex[term] = append(postings, entry.EncryptedName)
+					break
+				}
+			}
+		}
+		if entry.Parity != nil {
+			// the parity file belongs to the original ciphertext
+			entry.Parity, err = v.writeParity(entry, entry.Parity.ParityShards)
+			if err != nil {
+				entry.Parity = nil
+				return fmt.Errorf("the ciphertext was copied, but its parity data could not be computed: %s", err.Error())
+			}
+		}
+		return nil
+	}
+}
+
+// fixSize returns a fix for an entry whose size and padding do not match its
+// ciphertext, of the given size.
+// The entry is only corrected if its ciphertext is intact, and decrypts to the
+// entry's checksum with the corrected size and padding.
+func (v *AESVault) fixSize(entry *AESVaultEntry, size int64) func() error {
+	return func() error {
+		if r := v.entryIntegrity(context.Background(), entry); r.Status != statusPassed {
+			return fmt.Errorf("the ciphertext cannot be trusted: %s", r.Reason)
+		}
+		if entry.Checksum == nil {
+			return fmt.Errorf("the entry has no checksum to check the corrected size against")
+		}
+		// the recorded padding is the likeliest to be correct
+		paddings := []int64{entry.Padding}
+		for p := int64(0); p < AES_BS; p++ {
+			if p != entry.Padding {
+				paddings = append(paddings, p)
+			}
+		}
+		for _, p := range paddings {
+			if p < 0 || p >= AES_BS || size-p < 0 {
+				continue
+			}
+			trial := *entry
+			trial.Size, trial.Padding = size-p, p
+			if v.entryIntegrityDeep(context.Background(), &trial).Status == statusPassed {
+				entry.Size, entry.Padding = trial.Size, trial.Padding
+				return nil
+			}
+		}
+		return fmt.Errorf("no size matches the entry's checksum")
+	}
+}
+
+// dropEntry returns a fix removing an entry whose ciphertext is missing; as
+// there is nothing left to restore, it is not moved to the trash.
+func (v *AESVault) dropEntry(entry *AESVaultEntry) func() error {
+	return func() error {
+		var kept []*AESVaultEntry
+		for _, e := range v.Files {
+			if e != entry {
+				kept = append(kept, e)
+			}
+		}
+		v.Files = kept
+		return v.deleteUnreferenced([]*AESVaultEntry{entry})
+	}
+}
+
+// dropTrashItem removes an item from the trash, without deleting its
+// ciphertext.
+func (v *AESVault) dropTrashItem(item *TrashItem) {
+	var kept []*TrashItem
+	for _, t := range v.Trash {
+		if t != item {
+			kept = append(kept, t)
+		}
+	}
+	v.Trash = kept
+}
+
+// parityEntries returns an entry for each ciphertext with parity data, of the
+// vault's entries, snapshots and trash.
+func (v *AESVault) parityEntries() []*AESVaultEntry {
+	var entries []*AESVaultEntry
+	seen := make(map[string]bool)
+	v.eachEntry(func(entry *AESVaultEntry) {
+		if entry.Parity != nil && !seen[entry.Parity.File] {
+			seen[entry.Parity.File] = true
+			entries = append(entries, entry)
+		}
+	})
+	return entries
+}
+
+// eachEntry calls fn for each of the vault's entries, including the entries of
+// its snapshots and trash.
+func (v *AESVault) eachEntry(fn func(entry *AESVaultEntry)) {
+	for _, entry := range v.Files {
+		fn(entry)
+	}
+	for _, snapshot := range v.Snapshots {
+		for _, entry := range snapshot.Files {
+			fn(entry)
+		}
+	}
+	for _, item := range v.Trash {
+		if item.Entry != nil {
+			fn(item.Entry)
+		}
+	}
+}
+
+// rewriteParity returns a fix recomputing the missing parity file of the
+// ciphertext of entry, if the ciphertext is intact.
+func (v *AESVault) rewriteParity(entry *AESVaultEntry) func() error {
+	return func() error {
+		if r := v.entryIntegrity(context.Background(), entry); r.Status != statusPassed {
+			return fmt.Errorf("the ciphertext cannot be trusted: %s", r.Reason)
+		}
+		parity, err := v.writeParity(entry, entry.Parity.ParityShards)
+		if err != nil {
+			return err
+		}
+		v.eachEntry(func(e *AESVaultEntry) {
+			if e.EncryptedName == entry.EncryptedName {
+				e.Parity = parity
+			}
+		})
+		return nil
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fsckTest saves the vault and checks it, failing unless Fsck finds exactly
+// the given number of problems of class, which are returned.
+func fsckTest(t *testing.T, v *AESVault, class string, count int) []*FsckProblem {
+	t.Helper()
+	if err := v.encodeToFile(); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := v.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []*FsckProblem
+	for _, p := range problems {
+		if p.Class == class {
+			found = append(found, p)
+		} else {
+			t.Errorf("unexpected %s problem for '%s': %s", p.Class, p.Name, p.Detail)
+		}
+	}
+	if len(found) != count {
+		t.Fatalf("found %d %s problems, want %d", len(found), class, count)
+	}
+	return found
+}
+
+// fsckFixTest fixes the problems of their class, failing if any is not fixed,
+// and checks that the vault has no problems left.
+func fsckFixTest(t *testing.T, v *AESVault, problems []*FsckProblem) {
+	t.Helper()
+	v.FsckFix(problems, map[string]bool{problems[0].Class: true})
+	for _, p := range problems {
+		if !p.Fixed {
+			t.Fatalf("%s problem for '%s' not fixed: %v", p.Class, p.Name, p.FixErr)
+		}
+	}
+	fsckTest(t, v, "", 0)
+}
+
+// writeOrphan writes a ciphertext which does not correspond to any entry to
+// the vault directory, returning its name.
+func writeOrphan(t *testing.T, v *AESVault) string {
+	t.Helper()
+	f, err := v.createCiphertext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(randomData(32))
+	f.Close()
+	return filepath.Base(f.Name())
+}
+
+func TestFsckClean(t *testing.T) {
+	v := newTestVault(t)
+	addTestFile(t, v, "x", randomData(100))
+	addTestFile(t, v, "x", randomData(200))
+	fsckTest(t, v, "", 0)
+}
+
+func TestFsckUnexpected(t *testing.T) {
+	v := newTestVault(t)
+	if err := os.Mkdir(filepath.Join(v.dirName, "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"notes.txt", ".parity-123"} {
+		if err := ioutil.WriteFile(filepath.Join(v.dirName, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsckFixTest(t, v, fsckTest(t, v, fsckUnexpected, 3))
+	for _, name := range []string{"dir", "notes.txt"} {
+		if _, err := os.Stat(filepath.Join(v.lostAndFound(), name)); err != nil {
+			t.Errorf("'%s' not moved to lost+found: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(v.dirName, ".parity-123")); !os.IsNotExist(err) {
+		t.Errorf("temporary file not deleted: %v", err)
+	}
+}
+
+func TestFsckOrphan(t *testing.T) {
+	v := newTestVault(t)
+	orphan := writeOrphan(t, v)
+	fsckFixTest(t, v, fsckTest(t, v, fsckOrphan, 1))
+	if len(v.Trash) != 1 || v.Trash[0].Ciphertext != orphan {
+		t.Fatalf("orphan not moved to the trash")
+	}
+	if _, err := v.EmptyTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(v.dirName, orphan)); !os.IsNotExist(err) {
+		t.Errorf("orphan not deleted with the trash: %v", err)
+	}
+}
+
+func TestFsckOrphanNoTrash(t *testing.T) {
+	v := newTestVault(t)
+	v.TrashDays = 0
+	orphan := writeOrphan(t, v)
+	fsckFixTest(t, v, fsckTest(t, v, fsckOrphan, 1))
+	if _, err := os.Stat(filepath.Join(v.lostAndFound(), orphan)); err != nil {
+		t.Errorf("orphan not moved to lost+found: %v", err)
+	}
+}
+
+func TestFsckGeneration(t *testing.T) {
+	v := newTestVault(t)
+	if err := v.encodeToFile(); err != nil {
+		t.Fatal(err)
+	}
+	params, err := readParams(v.dirName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Generation != 1 || params.Generation != 1 {
+		t.Fatalf("generations %d and %d after the first save, want 1", v.Generation, params.Generation)
+	}
+
+	// the params file was not updated at the last save
+	v.Generation++
+	problems, err := v.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Class != fsckIndex || problems[0].Name != paramsFile {
+		t.Fatalf("got %d problems, want an index problem for the params file", len(problems))
+	}
+	fsckFixTest(t, v, problems)
+}
+
+func TestFsckOlderCopy(t *testing.T) {
+	v := newTestVault(t)
+	addTestFile(t, v, "x", randomData(100))
+	if err := v.encodeToFile(); err != nil {
+		t.Fatal(err)
+	}
+	old, err := ioutil.ReadFile(v.dirName + vaultFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := addTestFile(t, v, "y", randomData(200))
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(v.dirName+vaultFile, old, 0666); err != nil {
+		t.Fatal(err)
+	}
+	v, err = OpenAESVault(v.dirName, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// saving the older copy must not hide it
+	if err := v.encodeToFile(); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := v.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 || problems[0].Class != fsckOrphan || problems[1].Class != fsckIndex {
+		t.Fatalf("got %d problems, want an orphan and an index problem", len(problems))
+	}
+	v.FsckFix(problems, map[string]bool{fsckOrphan: true, fsckIndex: true})
+	for _, p := range problems {
+		if !p.Fixed {
+			t.Fatalf("%s problem for '%s' not fixed: %v", p.Class, p.Name, p.FixErr)
+		}
+	}
+	fsckTest(t, v, "", 0)
+	// the ciphertext added since the copy must not expire with the trash
+	if len(v.Trash) != 0 {
+		t.Errorf("%d items in the trash", len(v.Trash))
+	}
+	if _, err := os.Stat(filepath.Join(v.lostAndFound(), added.EncryptedName)); err != nil {
+		t.Errorf("orphan not moved to lost+found: %v", err)
+	}
+}
+
+func TestFsckMissing(t *testing.T) {
+	v := newTestVault(t)
+	entry := addTestFile(t, v, "x", randomData(100))
+	if err := os.Remove(v.ciphertextPath(entry)); err != nil {
+		t.Fatal(err)
+	}
+	fsckFixTest(t, v, fsckTest(t, v, fsckMissing, 1))
+	if len(v.Files) != 0 || len(v.Trash) != 0 {
+		t.Errorf("%d entries and %d trash items left, want none", len(v.Files), len(v.Trash))
+	}
+}
+
+func TestFsckDuplicateName(t *testing.T) {
+	v := newTestVault(t)
+	first := addTestFile(t, v, "x", randomData(100))
+	second := addTestFile(t, v, "x", randomData(200))
+	duplicate := first.clone()
+	v.Files = append(v.Files, duplicate)
+	second.Version = first.Version
+	fsckFixTest(t, v, fsckTest(t, v, fsckDuplicateName, 1))
+	if len(v.Files) != 2 || first.Version != 1 || second.Version != 2 {
+		t.Errorf("got %d entries, versions %d and %d", len(v.Files), first.Version, second.Version)
+	}
+}
+
+func TestFsckDuplicateNameTrash(t *testing.T) {
+	v := newTestVault(t)
+	first := addTestFile(t, v, "x", randomData(100))
+	second := addTestFile(t, v, "x", randomData(200))
+	trashed := addTestFile(t, v, "x", randomData(300))
+	if err := v.RemoveEntries([]*AESVaultEntry{trashed}); err != nil {
+		t.Fatal(err)
+	}
+	// the renumbered version must not collide with the version in the trash
+	first.Version = second.Version
+	fsckFixTest(t, v, fsckTest(t, v, fsckDuplicateName, 1))
+	if first.Version != 2 || second.Version != 4 || trashed.Version != 3 {
+		t.Errorf("got versions %d, %d and %d, want 2, 4 and 3", first.Version, second.Version, trashed.Version)
+	}
+}
+
+func TestFsckDuplicateCiphertext(t *testing.T) {
+	v := newTestVault(t)
+	data := randomData(100)
+	entry := addTestFile(t, v, "x", data)
+	shared := entry.clone()
+	shared.Filename = "y"
+	v.Files = append(v.Files, shared)
+	fsckFixTest(t, v, fsckTest(t, v, fsckDuplicateCiphertext, 1))
+	if shared.EncryptedName == entry.EncryptedName {
+		t.Fatal("the ciphertext is still shared")
+	}
+	if got := readTestEntry(t, v, shared); !bytes.Equal(got, data) {
+		t.Error("the copy has the wrong contents")
+	}
+}
+
+func TestFsckSize(t *testing.T) {
+	v := newTestVault(t)
+	entry := addTestFile(t, v, "x", randomData(100))
+	entry.Size += AES_BS
+	fsckFixTest(t, v, fsckTest(t, v, fsckSize, 1))
+	if entry.Size != 100 {
+		t.Errorf("size corrected to %d, want 100", entry.Size)
+	}
+	padding := entry.Padding
+	entry.Padding = AES_BS
+	fsckFixTest(t, v, fsckTest(t, v, fsckSize, 1))
+	if entry.Size != 100 || entry.Padding != padding {
+		t.Errorf("corrected to size %d and padding %d, want 100 and %d", entry.Size, entry.Padding, padding)
+	}
+}
+
+func TestFsckIndex(t *testing.T) {
+	v := newTestVault(t)
+	v.TextIndex = map[string][]string{"term": {"deleted"}}
+	fsckFixTest(t, v, fsckTest(t, v, fsckIndex, 2))
+	if v.TextIndex != nil {
+		t.Error("the full-text index was not dropped")
+	}
+}
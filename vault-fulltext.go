@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// The full-text index is an inverted index mapping each term to the
+// ciphertexts whose plaintexts contain the term.
+// It is stored in the vault file, so it is encrypted along with the rest of
+// the vault's entries, and allows files to be searched without decrypting them.
+// Since ciphertexts are never modified, a ciphertext's postings are only
+// removed once the ciphertext is deleted.
+
+// SetFullText enables or disables the full-text index of the vault.
+// When the index is enabled, the latest version of each file in the vault is
+// decrypted (in memory) and indexed; disabling the index discards it.
+func (v *AESVault) SetFullText(enabled bool) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	v.FullText = enabled
+	v.TextIndex = nil
+	if !enabled {
+		return nil
+	}
+	for _, entry := range v.latestEntries() {
+		if entry.Size > maxIndexedSize {
+			continue
+		}
+		var buff bytes.Buffer
+		if err := v.readEntry(entry, &buff); err != nil {
+			return fmt.Errorf("error indexing '%s': %s", entry.Filename, err.Error())
+		}
+		v.indexText(entry, buff.Bytes())
+	}
+	return nil
+}
+
+// indexFile adds the text of the file at srcPath, whose ciphertext corresponds
+// to entry, to the full-text index (if it is enabled).
+// Indexing is best-effort: files which are too large or which contain no
+// extractable text are not indexed.
+func (v *AESVault) indexFile(entry *AESVaultEntry, srcPath string) {
+	if !v.FullText || entry.Size > maxIndexedSize {
+		return
+	}
+	data, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return
+	}
+	v.indexText(entry, data)
+}
+
+// indexText adds the terms of the text in data to the full-text index, with
+// postings to the ciphertext of entry.
+func (v *AESVault) indexText(entry *AESVaultEntry, data []byte) {
+	text, ok := extractText(data)
+	if !ok {
+		return
+	}
+	if v.TextIndex == nil {
+		v.TextIndex = make(map[string][]string)
+	}
+	for _, term := range tokenize(text) {
+		v.TextIndex[term] = append(v.TextIndex[term], entry.EncryptedName)
+	}
+}
+
+// unindex removes the postings to the given ciphertexts from the full-text
+// index.
+func (v *AESVault) unindex(ciphertexts map[string]bool) {
+	if len(ciphertexts) == 0 {
+		return
+	}
+	for term, postings := range v.TextIndex {
+		var kept []string
+		for _, p := range postings {
+			if !ciphertexts[p] {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(v.TextIndex, term)
+		} else {
+			v.TextIndex[term] = kept
+		}
+	}
+}
+
+// Search returns the latest version of each file whose text contains all the
+// terms in query.
+// A term ending in '*' matches all the terms with that prefix; if the term is
+// split into several words (e.g. "foo-bar*"), only the last word is a prefix.
+func (v *AESVault) Search(query string) ([]*AESVaultEntry, error) {
+	if !v.FullText {
+		return nil, fmt.Errorf("full-text index is not enabled for this vault")
+	}
+	var prefixes []string
+	for _, field := range strings.Fields(query) {
+		if !strings.HasSuffix(field, "*") {
+			prefixes = append(prefixes, tokenize(field)...)
+			continue
+		}
+		words := splitTerms(strings.TrimSuffix(field, "*"))
+		if len(words) == 0 {
+			continue
+		}
+		last := len(words) - 1
+		prefixes = append(prefixes, tokenize(strings.Join(words[:last], " "))...)
+		for _, term := range tokenize(words[last]) {
+			prefixes = append(prefixes, term+"*")
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("no search terms in query '%s'", query)
+	}
+	// intersect the postings of the query terms
+	var matches map[string]bool
+	for _, term := range prefixes {
+		postings := make(map[string]bool)
+		for t, ps := range v.TextIndex {
+			if t == term || (strings.HasSuffix(term, "*") && strings.HasPrefix(t, term[:len(term)-1])) {
+				for _, p := range ps {
+					if matches == nil || matches[p] {
+						postings[p] = true
+					}
+				}
+			}
+		}
+		matches = postings
+	}
+	var entries []*AESVaultEntry
+	for _, entry := range v.latestEntries() {
+		if matches[entry.EncryptedName] {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
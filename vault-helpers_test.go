@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// newTestVault creates an empty vault in a temporary directory, using the fast
+// key derivation function.
+func newTestVault(tb testing.TB) *AESVault {
+	tb.Helper()
+	params, err := NewVaultParams("aes-256", kdfSHA256)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	v, err := NewAESVault(filepath.Join(tb.TempDir(), "vault"), params, []byte("password"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return v
+}
+
+// randomData returns n bytes of (deterministic) random data.
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// writeTestFile writes data to a file in a temporary directory, returning its
+// path.
+func writeTestFile(tb testing.TB, name string, data []byte) string {
+	tb.Helper()
+	p := filepath.Join(tb.TempDir(), name)
+	if err := ioutil.WriteFile(p, data, 0600); err != nil {
+		tb.Fatal(err)
+	}
+	return p
+}
+
+// addTestFile adds a file with the given contents to the vault as name,
+// returning its entry.
+func addTestFile(tb testing.TB, v *AESVault, name string, data []byte) *AESVaultEntry {
+	tb.Helper()
+	if err := v.addFile(writeTestFile(tb, "src", data), name); err != nil {
+		tb.Fatal(err)
+	}
+	_, entry := v.lookupFile(name)
+	return entry
+}
+
+// reopenTestVault saves the vault and opens it again, as a command run after
+// the one which changed it would.
+func reopenTestVault(tb testing.TB, v *AESVault) *AESVault {
+	tb.Helper()
+	if err := v.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	reopened, err := OpenAESVault(v.dirName, []byte("password"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return reopened
+}
+
+// equalStrings reports whether a and b hold the same strings, in the same
+// order (treating nil and empty slices alike).
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each time a file is added under a name which already exists in the vault, a
+// new version of the file is stored alongside the previous ones.
+// A specific version of a file can be referred to as "name@version".
+
+// migrate upgrades the entries of vaults created before files were versioned:
+// entries with the same name are numbered in the order they were added.
+// Ciphertexts, which used to be referred to by their path relative to the
+// directory the vault was used from, are referred to by their base name.
+func (v *AESVault) migrate() {
+	base := func(name string) string { return filepath.Base(filepath.FromSlash(name)) }
+	for _, entry := range v.Files {
+		entry.EncryptedName = base(entry.EncryptedName)
+	}
+	for _, snapshot := range v.Snapshots {
+		for _, entry := range snapshot.Files {
+			entry.EncryptedName = base(entry.EncryptedName)
+		}
+	}
+	for term, names := range v.TextIndex {
+		for i := range names {
+			names[i] = base(names[i])
+		}
+		v.TextIndex[term] = names
+	}
+
+	versions := make(map[string]int)
+	for _, entry := range v.Files {
+		if entry.Version > versions[entry.Filename] {
+			versions[entry.Filename] = entry.Version
+		}
+	}
+	for _, entry := range v.Files {
+		if entry.Version == 0 {
+			versions[entry.Filename]++
+			entry.Version = versions[entry.Filename]
+		}
+	}
+}
+
+// addVersion adds entry to the vault as the latest version of its file and
+// applies the vault's retention policy to the file's versions.
+// The new version inherits the annotations of the previous version, to which
+// the vault's annotation for added files is applied.
+func (v *AESVault) addVersion(entry *AESVaultEntry) {
+	entry.Version = v.highestVersion(entry.Filename) + 1
+	if _, latest := v.lookupFile(entry.Filename); latest != nil {
+		inheritAnnotations(entry, latest)
+	}
+	v.annotation.apply(entry)
+	entry.Added = time.Now()
+	v.Files = append(v.Files, entry)
+	// errors deleting old versions are ignored; the entries are kept and
+	// retention is applied again on the next addition
+	v.applyRetention(entry.Filename)
+}
+
+// highestVersion returns the highest version of the file called name among the
+// entries of the vault, of the trash and of the snapshots, so that a new
+// version never reuses the number of a removed one which could be restored.
+func (v *AESVault) highestVersion(name string) int {
+	highest := 0
+	check := func(entry *AESVaultEntry) {
+		if entry != nil && entry.Filename == name && entry.Version > highest {
+			highest = entry.Version
+		}
+	}
+	for _, entry := range v.Files {
+		check(entry)
+	}
+	for _, item := range v.Trash {
+		check(item.Entry)
+	}
+	for _, snapshot := range v.Snapshots {
+		for _, entry := range snapshot.Files {
+			check(entry)
+		}
+	}
+	return highest
+}
+
+// splitVersion splits a "name@version" reference into its name and version.
+// If the reference does not specify a version, the version returned is 0.
+func splitVersion(ref string) (string, int) {
+	idx := strings.LastIndex(ref, "@")
+	if idx == -1 {
+		return ref, 0
+	}
+	version, err := strconv.Atoi(ref[idx+1:])
+	if err != nil || version <= 0 {
+		return ref, 0
+	}
+	return ref[:idx], version
+}
+
+// resolveFile returns the entry referred to by ref, which is either the name of
+// a file (referring to its latest version) or a "name@version" reference.
+// Names are matched exactly before being interpreted as references, so files
+// whose names contain '@' remain accessible.
+func (v *AESVault) resolveFile(ref string) *AESVaultEntry {
+	if _, entry := v.lookupFile(ref); entry != nil {
+		return entry
+	}
+	name, version := splitVersion(ref)
+	if version == 0 {
+		return nil
+	}
+	for _, entry := range v.Files {
+		if entry.Filename == name && entry.Version == version {
+			return entry
+		}
+	}
+	return nil
+}
+
+// latestEntries returns the latest version of each file in the vault, in the
+// order in which the files were first added.
+func (v *AESVault) latestEntries() []*AESVaultEntry {
+	var entries []*AESVaultEntry
+	index := make(map[string]int)
+	for _, entry := range v.Files {
+		i, seen := index[entry.Filename]
+		if !seen {
+			index[entry.Filename] = len(entries)
+			entries = append(entries, entry)
+		} else if entry.Version > entries[i].Version {
+			entries[i] = entry
+		}
+	}
+	return entries
+}
+
+// History returns all the versions of the file with the given name, from
+// oldest to newest.
+func (v *AESVault) History(name string) ([]*AESVaultEntry, error) {
+	var versions []*AESVaultEntry
+	for _, entry := range v.Files {
+		if entry.Filename == name {
+			versions = append(versions, entry)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no entry for '%s' in vault", name)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// SetMaxVersions sets the number of versions of each file which are retained
+// in the vault (0 retains all versions) and prunes the versions exceeding it.
+// It returns the references ("name@version") of the pruned versions.
+func (v *AESVault) SetMaxVersions(n int) ([]string, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid number of versions: %d", n)
+	}
+	v.MaxVersions = n
+	versions := make(map[string][]*AESVaultEntry)
+	for _, entry := range v.Files {
+		versions[entry.Filename] = append(versions[entry.Filename], entry)
+	}
+	var expired []*AESVaultEntry
+	for _, entry := range v.latestEntries() {
+		expired = append(expired, v.expiredVersions(versions[entry.Filename])...)
+	}
+	return v.pruneVersions(expired)
+}
+
+// applyRetention removes the oldest versions of the file with the given name,
+// so that at most MaxVersions versions remain.
+// It returns the references ("name@version") of the removed versions.
+func (v *AESVault) applyRetention(name string) ([]string, error) {
+	var versions []*AESVaultEntry
+	for _, entry := range v.Files {
+		if entry.Filename == name {
+			versions = append(versions, entry)
+		}
+	}
+	return v.pruneVersions(v.expiredVersions(versions))
+}
+
+// expiredVersions returns the oldest of the given versions of a file which
+// exceed MaxVersions.
+func (v *AESVault) expiredVersions(versions []*AESVaultEntry) []*AESVaultEntry {
+	if v.MaxVersions == 0 || len(versions) <= v.MaxVersions {
+		return nil
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions[:len(versions)-v.MaxVersions]
+}
+
+// pruneVersions removes the expired versions from the vault, returning their
+// references ("name@version").
+func (v *AESVault) pruneVersions(versions []*AESVaultEntry) ([]string, error) {
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	expired := make(map[*AESVaultEntry]bool)
+	var pruned []string
+	for _, entry := range versions {
+		expired[entry] = true
+		pruned = append(pruned, fmt.Sprintf("%s@%d", entry.Filename, entry.Version))
+	}
+	return pruned, v.removeEntries(func(entry *AESVaultEntry) bool { return expired[entry] })
+}
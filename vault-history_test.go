@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	v := newTestVault(t)
+	var data [][]byte
+	for i := 1; i <= 3; i++ {
+		data = append(data, randomData(100*i))
+		if entry := addTestFile(t, v, "x", data[i-1]); entry.Version != i {
+			t.Fatalf("version %d added as %d", i, entry.Version)
+		}
+	}
+	addTestFile(t, v, "y", randomData(10))
+	for i, want := range data {
+		entry := v.resolveFile(fmt.Sprintf("x@%d", i+1))
+		if entry == nil || entry.Version != i+1 {
+			t.Fatalf("x@%d not resolved", i+1)
+		}
+		if got := readTestEntry(t, v, entry); !bytes.Equal(got, want) {
+			t.Errorf("x@%d has the wrong contents", i+1)
+		}
+	}
+	if entry := v.resolveFile("x"); entry == nil || entry.Version != 3 {
+		t.Error("x does not resolve to its latest version")
+	}
+	for _, ref := range []string{"x@4", "x@0", "x@-1", "x@", "y@2"} {
+		if v.resolveFile(ref) != nil {
+			t.Errorf("'%s' resolved", ref)
+		}
+	}
+	history, err := v.History("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range history {
+		if entry.Version != i+1 {
+			t.Errorf("version %d listed at position %d", entry.Version, i)
+		}
+	}
+	if _, err := v.History("z"); err == nil {
+		t.Error("history of a missing file")
+	}
+	var latest []string
+	for _, entry := range v.latestEntries() {
+		latest = append(latest, fmt.Sprintf("%s@%d", entry.Filename, entry.Version))
+	}
+	if want := []string{"x@3", "y@1"}; !reflect.DeepEqual(latest, want) {
+		t.Errorf("latest entries %v, want %v", latest, want)
+	}
+}
+
+func TestVersionNameWithAt(t *testing.T) {
+	v := newTestVault(t)
+	addTestFile(t, v, "a@2", randomData(10))
+	if entry := v.resolveFile("a@2"); entry == nil || entry.Filename != "a@2" {
+		t.Fatal("file named 'a@2' not resolved")
+	}
+	if entry := v.resolveFile("a@2@1"); entry == nil || entry.Filename != "a@2" {
+		t.Error("'a@2@1' not resolved")
+	}
+}
+
+func TestMaxVersions(t *testing.T) {
+	v := newTestVault(t)
+	for i := 0; i < 4; i++ {
+		addTestFile(t, v, "x", randomData(10+i))
+	}
+	pruned, err := v.SetMaxVersions(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x@1", "x@2"}; !reflect.DeepEqual(pruned, want) {
+		t.Fatalf("pruned %v, want %v", pruned, want)
+	}
+	if len(v.Trash) != 2 {
+		t.Errorf("%d pruned versions in the trash, want 2", len(v.Trash))
+	}
+	// retention applies to new versions
+	entry := addTestFile(t, v, "x", randomData(20))
+	history, _ := v.History("x")
+	if len(history) != 2 || history[0].Version != 4 || history[1] != entry || entry.Version != 5 {
+		t.Errorf("got %d versions after adding version %d", len(history), entry.Version)
+	}
+	if _, err := v.SetMaxVersions(-1); err == nil {
+		t.Error("negative number of versions accepted")
+	}
+}
+
+func TestMigrateVersions(t *testing.T) {
+	v := newTestVault(t)
+	var entries []*AESVaultEntry
+	for i := 0; i < 3; i++ {
+		entry := addTestFile(t, v, "x", randomData(10+i))
+		entry.Version = 0
+		entries = append(entries, entry)
+	}
+	entry := addTestFile(t, v, "y", randomData(20))
+	entry.Version = 0
+	v.migrate()
+	for i, entry := range entries {
+		if entry.Version != i+1 {
+			t.Errorf("entry %d of x migrated to version %d", i, entry.Version)
+		}
+	}
+	if entry.Version != 1 {
+		t.Errorf("y migrated to version %d", entry.Version)
+	}
+}
+
+func BenchmarkLatestEntries(b *testing.B) {
+	v := &AESVault{}
+	for i := 0; i < 10000; i++ {
+		v.Files = append(v.Files, &AESVaultEntry{Filename: fmt.Sprintf("file%d", i%5000), Version: i/5000 + 1})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(v.latestEntries()) != 5000 {
+			b.Fatal("wrong number of latest entries")
+		}
+	}
+}
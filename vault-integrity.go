@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+)
+
+// Statuses of the integrity test of an entry.
+const (
+	// the ciphertext is intact
+	statusPassed = "passed"
+	// the ciphertext seems to be malformed or tampered with
+	statusFailed = "failed"
+	// the test could not be completed, for example because the ciphertext is
+	// missing or could not be read
+	statusInconclusive = "inconclusive"
+)
+
+// Assessments of the damage to a ciphertext which has parity data.
+const (
+	// the damaged chunks can be reconstructed from the parity data
+	damageRepairable = "repairable"
+	// too many chunks are damaged to be reconstructed
+	damageUnrecoverable = "unrecoverable"
+)
+
+// EntryIntegrity is the result of the integrity test of an entry.
+type EntryIntegrity struct {
+	Entry  *AESVaultEntry
+	Status string
+	// Reason explains why the test failed or was inconclusive
+	Reason string
+	// Damage tells whether a ciphertext which failed the test can be repaired
+	// with its parity data (damageRepairable or damageUnrecoverable); it is
+	// empty if the ciphertext has no parity data, or if its parity data shows
+	// no damage (e.g. only the entry is corrupt)
+	Damage string
+}
+
+// IntegrityOptions configure an integrity test of the vault.
+type IntegrityOptions struct {
+	// Concurrency is the maximum number of entries tested concurrently;
+	// DefaultWorkers if it is 0
+	Concurrency int
+	// Deep also decrypts each entry (in memory) and checks its padding, size
+	// and plaintext checksum, which catches corrupt entries (e.g. with a wrong
+	// IV or padding) whose ciphertext is intact
+	Deep bool
+	// Entries are the entries to test; all the entries of the vault if nil
+	Entries []*AESVaultEntry
+	// OnResult, if non-nil, is called with the result of each test as soon as
+	// it completes, along with the number of completed tests and the total
+	// number of tests.
+	// It is not called concurrently.
+	OnResult func(result EntryIntegrity, done, total int)
+}
+
+// Verify tests the integrity of the ciphertexts of the entries in the vault (or
+// of opts.Entries), testing up to opts.Concurrency entries concurrently.
+// The results are returned in the order of the entries, regardless of the
+// order in which the tests complete.
+// If ctx is cancelled, the tests which have not completed are abandoned, and
+// the results of the completed ones are returned along with the context's
+// error.
+func (v *AESVault) Verify(ctx context.Context, opts IntegrityOptions) ([]EntryIntegrity, error) {
+	workers := opts.Concurrency
+	if workers == 0 {
+		workers = DefaultWorkers
+	}
+	entries := opts.Entries
+	if entries == nil {
+		entries = v.Files
+	}
+	results := make([]EntryIntegrity, len(entries))
+	completed := make([]bool, len(entries))
+	var mu sync.Mutex
+	done := 0
+	runWorkers(len(entries), workers, func(i int) {
+		if ctx.Err() != nil {
+			return
+		}
+		var result EntryIntegrity
+		if opts.Deep {
+			result = v.entryIntegrityDeep(ctx, entries[i])
+		} else {
+			result = v.entryIntegrity(ctx, entries[i])
+		}
+		if result.Status != statusPassed {
+			v.assessDamage(ctx, &result)
+		}
+		if ctx.Err() != nil {
+			// the test was interrupted
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		results[i], completed[i] = result, true
+		done++
+		if opts.OnResult != nil {
+			opts.OnResult(result, done, len(entries))
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		var partial []EntryIntegrity
+		for i, r := range results {
+			if completed[i] {
+				partial = append(partial, r)
+			}
+		}
+		return partial, err
+	}
+	return results, nil
+}
+
+// entryIntegrity checks the integrity of the ciphertext corresponding to
+// entry, by recomputing its HMAC tag.
+func (v *AESVault) entryIntegrity(ctx context.Context, entry *AESVaultEntry) EntryIntegrity {
+	inconclusive := func(format string, a ...interface{}) EntryIntegrity {
+		return EntryIntegrity{Entry: entry, Status: statusInconclusive, Reason: fmt.Sprintf(format, a...)}
+	}
+	failed := func(reason string) EntryIntegrity {
+		return EntryIntegrity{Entry: entry, Status: statusFailed, Reason: reason}
+	}
+	f, err := os.Open(v.ciphertextPath(entry))
+	if err != nil {
+		return inconclusive("error opening ciphertext file: %s", err.Error())
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return inconclusive("error obtaining file info: %s", err.Error())
+	}
+	// file size must be a multiple of AES_BS (AES cipher block size)
+	if info.Size()%AES_BS != 0 {
+		return failed(fmt.Sprintf("ciphertext size %d is not a multiple of the block size", info.Size()))
+	}
+	// compute sum and ensure it is the same as in the entry
+	mac := hmac.New(sha256.New, v.key)
+	buf := make([]byte, cryptChunkSize)
+	if _, err := io.CopyBuffer(mac, &contextReader{ctx: ctx, r: io.LimitReader(f, info.Size())}, buf); err != nil {
+		return inconclusive("error reading ciphertext file: %s", err.Error())
+	}
+	if !hmac.Equal(mac.Sum(nil), entry.HMAC) {
+		return failed("HMAC mismatch: the ciphertext was modified")
+	}
+	return EntryIntegrity{Entry: entry, Status: statusPassed}
+}
+
+// entryIntegrityDeep checks the integrity of entry by decrypting its
+// ciphertext: besides the HMAC tag, the size and padding of the ciphertext
+// must match the entry and the plaintext must match the recorded checksum (for
+// entries which have one).
+func (v *AESVault) entryIntegrityDeep(ctx context.Context, entry *AESVaultEntry) EntryIntegrity {
+	failed := func(format string, a ...interface{}) EntryIntegrity {
+		return EntryIntegrity{Entry: entry, Status: statusFailed, Reason: fmt.Sprintf(format, a...)}
+	}
+	f, err := os.Open(v.ciphertextPath(entry))
+	if err != nil {
+		return EntryIntegrity{Entry: entry, Status: statusInconclusive, Reason: fmt.Sprintf("error opening ciphertext file: %s", err.Error())}
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return EntryIntegrity{Entry: entry, Status: statusInconclusive, Reason: fmt.Sprintf("error obtaining file info: %s", err.Error())}
+	}
+	if entry.Padding < 0 || entry.Padding >= AES_BS || (entry.Size+entry.Padding)%AES_BS != 0 {
+		return failed("invalid padding %d for a file of %d bytes", entry.Padding, entry.Size)
+	}
+	if info.Size() != entry.Size+entry.Padding {
+		return failed("ciphertext size %d does not match the file size %d and padding %d", info.Size(), entry.Size, entry.Padding)
+	}
+	// decrypt the padding too, which must be zeros
+	raw := *entry
+	raw.Padding = 0
+	check := &plaintextCheck{ctx: ctx, size: entry.Size, sum: sha256.New()}
+	if err := v.decrypt(&raw, f, check); err == errAuthFailed {
+		return failed("HMAC mismatch: the ciphertext was modified")
+	} else if err != nil {
+		return EntryIntegrity{Entry: entry, Status: statusInconclusive, Reason: err.Error()}
+	}
+	if check.badPadding {
+		return failed("the padding does not decrypt to zeros: the padding of the entry is wrong")
+	}
+	if entry.Checksum != nil && !bytes.Equal(check.sum.Sum(nil), entry.Checksum) {
+		return failed("checksum mismatch: the entry does not decrypt to the original file (wrong IV?)")
+	}
+	return EntryIntegrity{Entry: entry, Status: statusPassed}
+}
+
+// plaintextCheck computes the checksum of the first size bytes written to it
+// and checks that the remaining bytes (the padding) are zeros.
+// Writing fails once its context is cancelled.
+type plaintextCheck struct {
+	ctx        context.Context
+	size       int64
+	written    int64
+	sum        hash.Hash
+	badPadding bool
+}
+
+func (c *plaintextCheck) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	if c.written < c.size {
+		data := p
+		if int64(len(data)) > c.size-c.written {
+			data = data[:c.size-c.written]
+		}
+		c.sum.Write(data)
+		c.written += int64(len(data))
+		p = p[len(data):]
+	}
+	for _, b := range p {
+		if b != 0 {
+			c.badPadding = true
+		}
+	}
+	return n, nil
+}
+
+// contextReader is a reader which fails once its context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
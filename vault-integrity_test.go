@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	v := newTestVault(t)
+	var entries []*AESVaultEntry
+	for i, name := range []string{"intact", "modified", "missing", "size", "padding", "iv", "checksum", "legacy"} {
+		entries = append(entries, addTestFile(t, v, name, randomData(100+i*16)))
+	}
+	corrupt(t, v.ciphertextPath(entries[1]), 0)
+	if err := os.Remove(v.ciphertextPath(entries[2])); err != nil {
+		t.Fatal(err)
+	}
+	entries[3].Size += AES_BS
+	// the size and padding still add up, but the last byte of the file is
+	// taken for padding
+	if data := randomData(int(entries[4].Size)); data[len(data)-1] == 0 {
+		t.Fatal("the last byte of the test file is zero")
+	}
+	entries[4].Size--
+	entries[4].Padding++
+	entries[5].IV[0] ^= 1
+	entries[6].Checksum[0] ^= 1
+	// entries added by earlier versions have no checksum
+	entries[7].Checksum = nil
+
+	for _, test := range []struct {
+		deep bool
+		want []string
+	}{
+		{false, []string{statusPassed, statusFailed, statusInconclusive, statusPassed, statusPassed, statusPassed, statusPassed, statusPassed}},
+		{true, []string{statusPassed, statusFailed, statusInconclusive, statusFailed, statusFailed, statusFailed, statusFailed, statusPassed}},
+	} {
+		results, err := v.Verify(context.Background(), IntegrityOptions{Deep: test.deep, Concurrency: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(entries) {
+			t.Fatalf("%d results for %d entries", len(results), len(entries))
+		}
+		for i, r := range results {
+			if r.Entry != entries[i] {
+				t.Fatalf("result %d is for '%s'", i, r.Entry.Filename)
+			}
+			if r.Status != test.want[i] {
+				t.Errorf("deep %v: '%s' %s (%s), want %s", test.deep, r.Entry.Filename, r.Status, r.Reason, test.want[i])
+			}
+		}
+		if test.deep {
+			if reason := results[4].Reason; !strings.Contains(reason, "padding of the entry is wrong") {
+				t.Errorf("wrong padding reported as %q", reason)
+			}
+			if reason := results[6].Reason; !strings.Contains(reason, "checksum mismatch") {
+				t.Errorf("wrong checksum reported as %q", reason)
+			}
+		}
+	}
+}
+
+func TestVerifyCancel(t *testing.T) {
+	v := newTestVault(t)
+	for i := 0; i < 8; i++ {
+		addTestFile(t, v, "x", randomData(1000+i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	results, err := v.Verify(ctx, IntegrityOptions{Concurrency: 1, OnResult: func(_ EntryIntegrity, done, total int) {
+		calls++
+		if done == 3 {
+			cancel()
+		}
+	}})
+	if err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if len(results) != 3 || calls != 3 {
+		t.Errorf("%d results and %d progress calls, want 3", len(results), calls)
+	}
+}
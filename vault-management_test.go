@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnlinkedCiphertexts(t *testing.T) {
+	v := newTestVault(t)
+	entry := addTestFile(t, v, "x", randomData(10))
+	trashed := addTestFile(t, v, "y", randomData(20))
+	if err := v.RemoveEntries([]*AESVaultEntry{trashed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.encodeToFile(); err != nil {
+		t.Fatal(err)
+	}
+	orphan := writeOrphan(t, v)
+	unlinked, err := v.UnlinkedCiphertexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(unlinked, []string{orphan}) {
+		t.Fatalf("unlinked ciphertexts %v, want [%s]", unlinked, orphan)
+	}
+	v.DeleteCiphertexts(unlinked)
+	if len(v.Trash) != 2 || v.Trash[1].Ciphertext != orphan {
+		t.Fatal("the unlinked ciphertext was not moved to the trash")
+	}
+	if unlinked, _ := v.UnlinkedCiphertexts(); len(unlinked) != 0 {
+		t.Errorf("ciphertexts in the trash reported as unlinked: %v", unlinked)
+	}
+
+	v.TrashDays = 0
+	v.DeleteCiphertexts([]string{orphan})
+	if _, err := os.Stat(filepath.Join(v.dirName, orphan)); !os.IsNotExist(err) {
+		t.Errorf("unlinked ciphertext not deleted: %v", err)
+	}
+	if _, err := os.Stat(v.ciphertextPath(entry)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMissingCiphertexts(t *testing.T) {
+	v := newTestVault(t)
+	addTestFile(t, v, "x", randomData(10))
+	missing := addTestFile(t, v, "y", randomData(20))
+	if err := os.Remove(v.ciphertextPath(missing)); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.MissingCiphertexts(); len(got) != 1 || got[0] != missing {
+		t.Errorf("%d entries with missing ciphertexts, want y", len(got))
+	}
+}
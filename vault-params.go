@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// paramsFile stores the (non-secret) parameters needed to derive the key of a
+// vault: the AES variant and the key derivation function.
+// It is stored in plaintext alongside the vault file, since the key is needed
+// to decrypt the vault file itself.
+// Vaults created before the parameters were configurable do not have it, and
+// use AES-256 with a SHA-256 derived key.
+const paramsFile = "params.json"
+
+// Key derivation functions.
+const (
+	// kdfSHA256 uses the SHA-256 hash of the password as the key
+	kdfSHA256 = "sha256"
+	// kdfScrypt derives the key from the password and a random salt using
+	// scrypt, which makes guessing passwords expensive
+	kdfScrypt = "scrypt"
+)
+
+// Default scrypt cost parameters, as recommended for interactive logins.
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+)
+
+// KDFParams describes how the key of a vault is derived from its password.
+type KDFParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt,omitempty"`
+	N    int    `json:"n,omitempty"`
+	R    int    `json:"r,omitempty"`
+	P    int    `json:"p,omitempty"`
+}
+
+// VaultParams are the parameters of a vault's encryption.
+type VaultParams struct {
+	Cipher string    `json:"cipher"`
+	KDF    KDFParams `json:"kdf"`
+	// Generation is the generation of the vault file when it was last saved
+	// (see AESVault.Generation); 0 for vaults which were never saved since
+	// generations were introduced
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+// legacyParams are the parameters of vaults without a params file.
+var legacyParams = VaultParams{Cipher: "aes-256", KDF: KDFParams{Name: kdfSHA256}}
+
+// NewVaultParams returns the parameters for a new vault using the given cipher
+// ("aes-256", "aes-192" or "aes-128") and key derivation function ("scrypt" or
+// "sha256"), generating a random salt if needed.
+func NewVaultParams(cipher, kdf string) (VaultParams, error) {
+	params := VaultParams{Cipher: strings.ToLower(cipher), KDF: KDFParams{Name: strings.ToLower(kdf)}}
+	if _, err := params.encryption(); err != nil {
+		return params, err
+	}
+	switch params.KDF.Name {
+	case kdfSHA256:
+	case kdfScrypt:
+		params.KDF.N, params.KDF.R, params.KDF.P = scryptN, scryptR, scryptP
+		params.KDF.Salt = make([]byte, scryptSaltLen)
+		if _, err := io.ReadFull(rand.Reader, params.KDF.Salt); err != nil {
+			return params, fmt.Errorf("failed to generate salt: %s", err.Error())
+		}
+	default:
+		return params, fmt.Errorf("unknown key derivation function '%s'", kdf)
+	}
+	return params, nil
+}
+
+// encryption returns the AES variant selected by the parameters.
+func (p VaultParams) encryption() (encType, error) {
+	switch p.Cipher {
+	case "aes-256":
+		return AES_256, nil
+	case "aes-192":
+		return AES_192, nil
+	case "aes-128":
+		return AES_128, nil
+	}
+	return 0, fmt.Errorf("unknown cipher '%s'", p.Cipher)
+}
+
+// deriveKey derives the key for the AES variant from the password.
+func (p VaultParams) deriveKey(password []byte) ([]byte, error) {
+	enc, err := p.encryption()
+	if err != nil {
+		return nil, err
+	}
+	switch p.KDF.Name {
+	case kdfSHA256:
+		return processKey(enc, password), nil
+	case kdfScrypt:
+		key, err := scrypt.Key(password, p.KDF.Salt, p.KDF.N, p.KDF.R, p.KDF.P, 32-int(enc)*8)
+		if err != nil {
+			return nil, fmt.Errorf("key derivation error: %s", err.Error())
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key derivation function '%s'", p.KDF.Name)
+}
+
+// readParams reads the parameters of the vault in the directory dir.
+func readParams(dir string) (VaultParams, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, paramsFile))
+	if os.IsNotExist(err) {
+		return legacyParams, nil
+	} else if err != nil {
+		return VaultParams{}, fmt.Errorf("error reading vault parameters: %s", err.Error())
+	}
+	var params VaultParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return VaultParams{}, fmt.Errorf("malformed vault parameters: %s", err.Error())
+	}
+	return params, nil
+}
+
+// writeParams writes the parameters of the vault in the directory dir.
+func writeParams(dir string, params VaultParams) error {
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, paramsFile), append(data, '\n'), 0666); err != nil {
+		return fmt.Errorf("error writing vault parameters: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Parity data allows damaged ciphertexts to be repaired.
+// A ciphertext is divided into shards of parityShardSize bytes, which are
+// grouped into stripes of parityDataShards shards. For each stripe, parity
+// shards are computed with a Reed-Solomon code (how many depends on the parity
+// overhead of the vault) and stored in a parity file next to the ciphertext.
+// The checksums of all the shards are recorded in the entry, so that damaged
+// shards can be located: a stripe can be repaired as long as it has no more
+// damaged shards than parity shards.
+
+const (
+	parityShardSize  = 64 << 10
+	parityDataShards = 16
+	// paritySuffix is appended to the name of a ciphertext to obtain the name
+	// of its parity file
+	paritySuffix = ".parity"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ParityInfo describes the parity data of a ciphertext.
+type ParityInfo struct {
+	// name of the parity file in the vault directory
+	File string
+	// size of the ciphertext the parity data was computed for
+	Size         int64
+	ShardSize    int
+	DataShards   int
+	ParityShards int
+	// CRC-32C checksums of the data shards (the chunks of the ciphertext),
+	// followed by those of the parity shards
+	Checksums []uint32
+}
+
+// parityShards returns the number of parity shards per stripe for an overhead
+// (as a percentage of the size of the ciphertexts).
+func parityShards(overhead int) int {
+	return (parityDataShards*overhead + 99) / 100
+}
+
+// numData returns the number of data shards of the ciphertext.
+func (p *ParityInfo) numData() int {
+	return int((p.Size + int64(p.ShardSize) - 1) / int64(p.ShardSize))
+}
+
+// numStripes returns the number of stripes of the ciphertext.
+func (p *ParityInfo) numStripes() int {
+	return (p.numData() + p.DataShards - 1) / p.DataShards
+}
+
+// shard locates the shard j (a data shard, if j < DataShards) of a stripe: it
+// is in the parity file if parity is set, at offset off, and its checksum is
+// Checksums[sum].
+// ok is false for the data shards past the end of the ciphertext, which are
+// taken to be zeros; the last data shard of the ciphertext may be shorter than
+// the others, in which case it is padded with zeros.
+func (p *ParityInfo) shard(stripe, j int) (parity bool, off int64, length, sum int, ok bool) {
+	if j >= p.DataShards {
+		i := stripe*p.ParityShards + j - p.DataShards
+		return true, int64(i) * int64(p.ShardSize), p.ShardSize, p.numData() + i, true
+	}
+	i := stripe*p.DataShards + j
+	if i >= p.numData() {
+		return false, 0, 0, 0, false
+	}
+	off = int64(i) * int64(p.ShardSize)
+	length = p.ShardSize
+	if p.Size-off < int64(length) {
+		length = int(p.Size - off)
+	}
+	return false, off, length, i, true
+}
+
+// parityPath returns the path of the parity file of entry.
+func (v *AESVault) parityPath(entry *AESVaultEntry) string {
+	return filepath.Join(v.dirName, entry.Parity.File)
+}
+
+// writeParity computes the parity data of the ciphertext of entry, with m
+// parity shards per stripe, and writes it to the entry's parity file.
+// It returns the parity data, which the caller records in the entries of the
+// ciphertext.
+func (v *AESVault) writeParity(entry *AESVaultEntry, m int) (*ParityInfo, error) {
+	src, err := os.Open(v.ciphertextPath(entry))
+	if err != nil {
+		return nil, fmt.Errorf("error opening ciphertext file: %s", err.Error())
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error obtaining file info: %s", err.Error())
+	}
+	info := &ParityInfo{
+		File:         filepath.Base(v.ciphertextPath(entry)) + paritySuffix,
+		Size:         stat.Size(),
+		ShardSize:    parityShardSize,
+		DataShards:   parityDataShards,
+		ParityShards: m,
+	}
+	code, err := newRSCode(info.DataShards, info.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := ioutil.TempFile(v.dirName, ".parity-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating parity file: %s", err.Error())
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	k := info.DataShards
+	shards := makeShards(k + m)
+	info.Checksums = make([]uint32, info.numData()+info.numStripes()*m)
+	for s := 0; s < info.numStripes(); s++ {
+		for j := 0; j < k; j++ {
+			buf := shards[j]
+			wipe(buf)
+			_, off, length, sum, ok := info.shard(s, j)
+			if !ok {
+				continue
+			}
+			if _, err := src.ReadAt(buf[:length], off); err != nil {
+				return nil, fmt.Errorf("file read error: %s", err.Error())
+			}
+			info.Checksums[sum] = crc32.Checksum(buf[:length], crcTable)
+		}
+		code.encode(shards[:k], shards[k:])
+		for j := k; j < k+m; j++ {
+			_, off, _, sum, _ := info.shard(s, j)
+			if _, err := dst.WriteAt(shards[j], off); err != nil {
+				return nil, fmt.Errorf("file write error: %s", err.Error())
+			}
+			info.Checksums[sum] = crc32.Checksum(shards[j], crcTable)
+		}
+	}
+	if err := dst.Close(); err != nil {
+		return nil, fmt.Errorf("file write error: %s", err.Error())
+	}
+	if err := os.Rename(dst.Name(), filepath.Join(v.dirName, info.File)); err != nil {
+		return nil, fmt.Errorf("error saving parity file: %s", err.Error())
+	}
+	return info, nil
+}
+
+func makeShards(n int) [][]byte {
+	shards := make([][]byte, n)
+	for i := range shards {
+		shards[i] = make([]byte, parityShardSize)
+	}
+	return shards
+}
+
+// ParityScan is the result of checking a ciphertext against its parity data.
+type ParityScan struct {
+	// number of damaged (or missing) shards, in the ciphertext or parity file
+	Damaged int
+	// number of stripes with more damaged shards than parity shards
+	Unrecoverable int
+	// BadSize is set if the ciphertext no longer has the size it had when the
+	// parity data was computed (e.g. it was truncated or appended to)
+	BadSize bool
+}
+
+// Intact reports whether the ciphertext and parity file are undamaged.
+func (s ParityScan) Intact() bool { return s.Damaged == 0 && !s.BadSize }
+
+// describeDamage describes the damage found by a ParityScan.
+func describeDamage(damaged int, badSize bool) string {
+	var parts []string
+	if damaged != 0 {
+		parts = append(parts, fmt.Sprintf("%d damaged chunks", damaged))
+	}
+	if badSize {
+		parts = append(parts, "wrong size")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Repairable reports whether the ciphertext or parity file is damaged, but can
+// be repaired.
+func (s ParityScan) Repairable() bool { return !s.Intact() && s.Unrecoverable == 0 }
+
+// CheckParity checks the shards of the ciphertext of entry, which must have
+// parity data, and of its parity file against their checksums.
+// If repair is set and the damage is repairable, the damaged shards are
+// reconstructed and the ciphertext and parity file are replaced by the repaired
+// ones, provided that the repaired ciphertext matches the entry's HMAC tag.
+func (v *AESVault) CheckParity(ctx context.Context, entry *AESVaultEntry, repair bool) (ParityScan, error) {
+	scan, err := v.parityPass(ctx, entry, nil, nil)
+	if err != nil || !repair || !scan.Repairable() {
+		return scan, err
+	}
+	if v.readOnly {
+		return scan, errReadOnly
+	}
+	ciphertext, err := ioutil.TempFile(v.dirName, ".repair-")
+	if err != nil {
+		return scan, fmt.Errorf("error creating repaired file: %s", err.Error())
+	}
+	defer os.Remove(ciphertext.Name())
+	defer ciphertext.Close()
+	parity, err := ioutil.TempFile(v.dirName, ".repair-")
+	if err != nil {
+		return scan, fmt.Errorf("error creating repaired file: %s", err.Error())
+	}
+	defer os.Remove(parity.Name())
+	defer parity.Close()
+	if _, err := v.parityPass(ctx, entry, ciphertext, parity); err != nil {
+		return scan, err
+	}
+	// the checksums cannot tell if the parity data was computed for the
+	// ciphertext the entry refers to: only replace it by a ciphertext which is
+	// known to be intact
+	mac := hmac.New(sha256.New, v.key)
+	if _, err := ciphertext.Seek(0, io.SeekStart); err != nil {
+		return scan, fmt.Errorf("file read error: %s", err.Error())
+	}
+	if _, err := io.CopyBuffer(mac, &contextReader{ctx: ctx, r: ciphertext}, make([]byte, cryptChunkSize)); err != nil {
+		return scan, fmt.Errorf("file read error: %s", err.Error())
+	}
+	if !hmac.Equal(mac.Sum(nil), entry.HMAC) {
+		return scan, fmt.Errorf("the repaired ciphertext does not match the entry's HMAC")
+	}
+	for _, f := range []*os.File{ciphertext, parity} {
+		if err := f.Sync(); err != nil {
+			return scan, fmt.Errorf("file write error: %s", err.Error())
+		}
+		if err := f.Close(); err != nil {
+			return scan, fmt.Errorf("file write error: %s", err.Error())
+		}
+	}
+	if err := os.Rename(ciphertext.Name(), v.ciphertextPath(entry)); err != nil {
+		return scan, fmt.Errorf("error replacing ciphertext file: %s", err.Error())
+	}
+	if err := os.Rename(parity.Name(), v.parityPath(entry)); err != nil {
+		return scan, fmt.Errorf("error replacing parity file: %s", err.Error())
+	}
+	return scan, nil
+}
+
+// parityPass reads the shards of the ciphertext of entry and of its parity
+// file, and checks them against their checksums.
+// If ciphertext and parity are non-nil, the damaged shards are reconstructed
+// and all the shards are written to them.
+func (v *AESVault) parityPass(ctx context.Context, entry *AESVaultEntry, ciphertext, parity *os.File) (ParityScan, error) {
+	var scan ParityScan
+	info := entry.Parity
+	code, err := newRSCode(info.DataShards, info.ParityShards)
+	if err != nil {
+		return scan, err
+	}
+	// missing files are read as damaged shards
+	src, _ := os.Open(v.ciphertextPath(entry))
+	if src != nil {
+		defer src.Close()
+		if stat, err := src.Stat(); err != nil || stat.Size() != info.Size {
+			scan.BadSize = true
+		}
+	} else {
+		scan.BadSize = true
+	}
+	paritySrc, _ := os.Open(v.parityPath(entry))
+	if paritySrc != nil {
+		defer paritySrc.Close()
+	}
+
+	k, m := info.DataShards, info.ParityShards
+	if len(info.Checksums) != info.numData()+info.numStripes()*m {
+		return scan, fmt.Errorf("invalid parity data")
+	}
+	shards := makeShards(k + m)
+	damaged := make([]bool, k+m)
+	for s := 0; s < info.numStripes(); s++ {
+		if err := ctx.Err(); err != nil {
+			return scan, err
+		}
+		n := 0
+		for j := range shards {
+			buf := shards[j]
+			wipe(buf)
+			isParity, off, length, sum, ok := info.shard(s, j)
+			damaged[j] = false
+			if !ok {
+				continue
+			}
+			f := src
+			if isParity {
+				f = paritySrc
+			}
+			if !readShard(f, buf[:length], off, info.Checksums[sum]) {
+				damaged[j] = true
+				n++
+			}
+		}
+		scan.Damaged += n
+		if n > m {
+			scan.Unrecoverable++
+			continue
+		}
+		if ciphertext == nil {
+			continue
+		}
+		if n > 0 {
+			if err := code.reconstruct(shards, damaged); err != nil {
+				return scan, err
+			}
+		}
+		for j := range shards {
+			isParity, off, length, _, ok := info.shard(s, j)
+			if !ok {
+				continue
+			}
+			f := ciphertext
+			if isParity {
+				f = parity
+			}
+			if _, err := f.WriteAt(shards[j][:length], off); err != nil {
+				return scan, fmt.Errorf("file write error: %s", err.Error())
+			}
+		}
+	}
+	return scan, nil
+}
+
+// readShard reads a shard of length len(buf) at offset off in f, and reports
+// whether it could be read and matches its checksum.
+func readShard(f *os.File, buf []byte, off int64, sum uint32) bool {
+	if f == nil {
+		return false
+	}
+	n, _ := f.ReadAt(buf, off)
+	return n == len(buf) && crc32.Checksum(buf, crcTable) == sum
+}
+
+// SetParity sets the parity overhead of the vault, as a percentage of the size
+// of the ciphertexts (up to 100); an overhead of 0 disables parity data.
+// Parity data is computed for the ciphertexts already in the vault which do not
+// have the corresponding amount of parity, or deleted if parity is disabled.
+// Parity data is only computed for ciphertexts which pass the integrity test,
+// since it would otherwise preserve the damage; the names of the files whose
+// ciphertexts failed are returned.
+func (v *AESVault) SetParity(overhead int) ([]string, error) {
+	if v.readOnly {
+		return nil, errReadOnly
+	}
+	if overhead < 0 || overhead > 100 {
+		return nil, fmt.Errorf("invalid parity overhead %d%%", overhead)
+	}
+	v.ParityOverhead = overhead
+	m := parityShards(overhead)
+
+	// the entries of each ciphertext, in the vault and its snapshots
+	byCiphertext := make(map[string][]*AESVaultEntry)
+	var ciphertexts []string
+	add := func(entries []*AESVaultEntry) {
+		for _, entry := range entries {
+			if byCiphertext[entry.EncryptedName] == nil {
+				ciphertexts = append(ciphertexts, entry.EncryptedName)
+			}
+			byCiphertext[entry.EncryptedName] = append(byCiphertext[entry.EncryptedName], entry)
+		}
+	}
+	add(v.Files)
+	for _, snapshot := range v.Snapshots {
+		add(snapshot.Files)
+	}
+
+	infos := make([]*ParityInfo, len(ciphertexts))
+	errs := make([]error, len(ciphertexts))
+	runWorkers(len(ciphertexts), DefaultWorkers, func(i int) {
+		entry := byCiphertext[ciphertexts[i]][0]
+		if m == 0 {
+			if entry.Parity != nil {
+				if err := os.Remove(v.parityPath(entry)); err != nil && !os.IsNotExist(err) {
+					errs[i] = fmt.Errorf("failed to delete parity file: %s", err.Error())
+				}
+			}
+			return
+		}
+		if entry.Parity != nil && entry.Parity.ParityShards == m {
+			infos[i] = entry.Parity
+			return
+		}
+		if result := v.entryIntegrity(context.Background(), entry); result.Status != statusPassed {
+			errs[i] = fmt.Errorf("%s", result.Reason)
+			return
+		}
+		infos[i], errs[i] = v.writeParity(entry, m)
+	})
+	var failed []string
+	for i, name := range ciphertexts {
+		entries := byCiphertext[name]
+		if errs[i] != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", entries[0].Filename, errs[i].Error()))
+			if m != 0 {
+				// keep the previous parity data, if any
+				continue
+			}
+		}
+		for _, entry := range entries {
+			entry.Parity = infos[i]
+		}
+	}
+	return failed, nil
+}
+
+// assessDamage records in the failed (or inconclusive) integrity test result r
+// whether the damage to the ciphertext can be repaired with its parity data.
+func (v *AESVault) assessDamage(ctx context.Context, r *EntryIntegrity) {
+	if r.Entry.Parity == nil {
+		return
+	}
+	scan, err := v.CheckParity(ctx, r.Entry, false)
+	if err != nil || scan.Intact() {
+		return
+	}
+	r.Damage = damageUnrecoverable
+	if scan.Repairable() {
+		r.Damage = damageRepairable
+	}
+	r.Reason += fmt.Sprintf(" (%s: %s)", r.Damage, describeDamage(scan.Damaged, scan.BadSize))
+}
+
+// RepairResult is the result of checking or repairing a ciphertext.
+type RepairResult struct {
+	Entry *AESVaultEntry
+	Scan  ParityScan
+	// Repaired is set if the ciphertext or its parity file was repaired
+	Repaired bool
+	// Err is set if the ciphertext could not be checked or repaired
+	Err error
+}
+
+// Repair checks the ciphertexts of the vault (and its snapshots) which have
+// parity data, repairing the damaged ones which are repairable, using up to
+// workers goroutines.
+// Each ciphertext is checked once, regardless of how many entries refer to it;
+// the results are returned in the order of the entries.
+// If ctx is cancelled, the results of the completed checks are returned along
+// with the context's error.
+func (v *AESVault) Repair(ctx context.Context, workers int) ([]RepairResult, error) {
+	seen := make(map[string]bool)
+	var entries []*AESVaultEntry
+	add := func(list []*AESVaultEntry) {
+		for _, entry := range list {
+			if entry.Parity != nil && !seen[entry.EncryptedName] {
+				seen[entry.EncryptedName] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	add(v.Files)
+	for _, snapshot := range v.Snapshots {
+		add(snapshot.Files)
+	}
+	results := make([]RepairResult, len(entries))
+	runWorkers(len(entries), workers, func(i int) {
+		if ctx.Err() != nil {
+			return
+		}
+		scan, err := v.CheckParity(ctx, entries[i], true)
+		results[i] = RepairResult{Entry: entries[i], Scan: scan, Err: err, Repaired: err == nil && scan.Repairable()}
+	})
+	if err := ctx.Err(); err != nil {
+		// only return the results of the completed checks
+		var partial []RepairResult
+		for _, r := range results {
+			if r.Entry != nil && r.Err != err {
+				partial = append(partial, r)
+			}
+		}
+		return partial, err
+	}
+	return results, nil
+}
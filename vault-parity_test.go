@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParityShard(t *testing.T) {
+	// two stripes, the second with a single short data shard
+	p := &ParityInfo{Size: 16*parityShardSize + 100, ShardSize: parityShardSize, DataShards: 16, ParityShards: 3}
+	if p.numData() != 17 || p.numStripes() != 2 {
+		t.Fatalf("got %d data shards in %d stripes, want 17 in 2", p.numData(), p.numStripes())
+	}
+	tests := []struct {
+		stripe, j int
+		parity    bool
+		off       int64
+		length    int
+		sum       int
+		ok        bool
+	}{
+		{0, 0, false, 0, parityShardSize, 0, true},
+		{0, 15, false, 15 * parityShardSize, parityShardSize, 15, true},
+		{1, 0, false, 16 * parityShardSize, 100, 16, true},
+		{1, 1, false, 0, 0, 0, false},
+		{1, 15, false, 0, 0, 0, false},
+		{0, 16, true, 0, parityShardSize, 17, true},
+		{0, 18, true, 2 * parityShardSize, parityShardSize, 19, true},
+		{1, 16, true, 3 * parityShardSize, parityShardSize, 20, true},
+		{1, 18, true, 5 * parityShardSize, parityShardSize, 22, true},
+	}
+	for _, tc := range tests {
+		parity, off, length, sum, ok := p.shard(tc.stripe, tc.j)
+		if parity != tc.parity || off != tc.off || length != tc.length || sum != tc.sum || ok != tc.ok {
+			t.Errorf("shard(%d, %d) = %v, %d, %d, %d, %v; want %v, %d, %d, %d, %v", tc.stripe, tc.j,
+				parity, off, length, sum, ok, tc.parity, tc.off, tc.length, tc.sum, tc.ok)
+		}
+	}
+}
+
+// corrupt flips a byte of the file at path in each of the given shards.
+func corrupt(t *testing.T, path string, shards ...int) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range shards {
+		data[s*parityShardSize+5] ^= 0xff
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckParity(t *testing.T) {
+	tests := []struct {
+		name string
+		// damaged shards of the ciphertext and of the parity file
+		data, parity []int
+		// bytes appended to the ciphertext
+		appended      int
+		unrecoverable bool
+	}{
+		{"intact", nil, nil, 0, false},
+		{"one data shard", []int{3}, nil, 0, false},
+		{"m data shards", []int{0, 5, 10, 15}, nil, 0, false},
+		{"short last shard", []int{32}, nil, 0, false},
+		{"parity shards", nil, []int{0, 5}, 0, false},
+		{"data and parity shards", []int{16, 17}, []int{4, 5}, 0, false},
+		{"appended", nil, nil, 10, false},
+		{"m+1 data shards", []int{0, 1, 2, 3, 4}, nil, 0, true},
+		{"m+1 data and parity shards", []int{0, 1, 2}, []int{0, 1}, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newTestVault(t)
+			if _, err := v.SetParity(25); err != nil {
+				t.Fatal(err)
+			}
+			// three stripes, the last one with a short shard
+			data := randomData(2*parityDataShards*parityShardSize + parityShardSize/2)
+			entry := addTestFile(t, v, "file", data)
+			if entry.Parity == nil || entry.Parity.ParityShards != 4 {
+				t.Fatalf("got parity %+v, want 4 parity shards", entry.Parity)
+			}
+			ciphertext, err := ioutil.ReadFile(v.ciphertextPath(entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			corrupt(t, v.ciphertextPath(entry), tc.data...)
+			corrupt(t, v.parityPath(entry), tc.parity...)
+			if tc.appended != 0 {
+				f, err := os.OpenFile(v.ciphertextPath(entry), os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Write(make([]byte, tc.appended))
+				f.Close()
+			}
+			damaged, err := ioutil.ReadFile(v.ciphertextPath(entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			scan, err := v.CheckParity(context.Background(), entry, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := scan.Damaged; got != len(tc.data)+len(tc.parity) {
+				t.Errorf("got %d damaged shards, want %d", got, len(tc.data)+len(tc.parity))
+			}
+			if scan.BadSize != (tc.appended != 0) {
+				t.Errorf("got BadSize %v", scan.BadSize)
+			}
+			after, err := ioutil.ReadFile(v.ciphertextPath(entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.unrecoverable {
+				if scan.Unrecoverable == 0 || scan.Repairable() {
+					t.Errorf("expected unrecoverable damage, got %+v", scan)
+				}
+				if !bytes.Equal(after, damaged) {
+					t.Errorf("the ciphertext of an unrecoverable entry was modified")
+				}
+				return
+			}
+			if !bytes.Equal(after, ciphertext) {
+				t.Fatalf("the ciphertext was not repaired")
+			}
+			var plaintext bytes.Buffer
+			if err := v.readEntry(entry, &plaintext); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plaintext.Bytes(), data) {
+				t.Errorf("the repaired ciphertext does not decrypt to the original")
+			}
+			if scan, err := v.CheckParity(context.Background(), entry, false); err != nil || !scan.Intact() {
+				t.Errorf("the repaired ciphertext is not intact: %+v, %v", scan, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Rename renames the file called oldName in the vault to newName, along with
+// all of its versions.
+// If oldName is instead a directory in the vault, all the files under it are
+// moved under newName.
+// Only the entries are updated; the ciphertexts are not modified.
+// Snapshots keep referring to the files by the names they had when the
+// snapshot was created.
+// If versions of a file with the new name are in the trash (or in snapshots),
+// the renamed versions are numbered after them, so that they can still be
+// restored.
+func (v *AESVault) Rename(oldName, newName string) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	oldName, newName = cleanEntryName(oldName), cleanEntryName(newName)
+	if oldName == "" || newName == "" {
+		return fmt.Errorf("cannot rename the root of the vault")
+	}
+	if oldName == newName {
+		return nil
+	}
+	// determine the new name of each affected entry
+	renames := make(map[*AESVaultEntry]string)
+	if _, entry := v.lookupFile(oldName); entry != nil {
+		for _, e := range v.Files {
+			if e.Filename == oldName {
+				renames[e] = newName
+			}
+		}
+	} else if strings.HasPrefix(newName, oldName+"/") {
+		return fmt.Errorf("cannot move '%s' into itself", oldName)
+	} else {
+		for _, e := range v.Files {
+			if strings.HasPrefix(e.Filename, oldName+"/") {
+				renames[e] = newName + strings.TrimPrefix(e.Filename, oldName)
+			}
+		}
+	}
+	if len(renames) == 0 {
+		return fmt.Errorf("no entry for '%s' in vault", oldName)
+	}
+	// check for conflicts with the entries which are not being renamed: a
+	// renamed file may not replace a file, nor be a file and a directory at
+	// once
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, e := range v.Files {
+		if renames[e] != "" {
+			continue
+		}
+		files[e.Filename] = true
+		for dir := path.Dir(e.Filename); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	for _, name := range renames {
+		if files[name] {
+			return fmt.Errorf("'%s' already exists in vault", name)
+		}
+		if dirs[name] {
+			return fmt.Errorf("'%s' is a directory in vault", name)
+		}
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if files[dir] {
+				return fmt.Errorf("'%s' is a file in vault", dir)
+			}
+		}
+	}
+	// number the renamed versions after the versions of their new names which
+	// are in the trash or in snapshots (there are no live ones, which would
+	// have conflicted)
+	renamed := make(map[string][]*AESVaultEntry)
+	for _, e := range v.Files {
+		if name := renames[e]; name != "" {
+			renamed[name] = append(renamed[name], e)
+		}
+	}
+	for name, entries := range renamed {
+		highest := v.highestVersion(name)
+		if highest == 0 {
+			continue
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Version < entries[j].Version })
+		for i, entry := range entries {
+			entry.Version = highest + i + 1
+		}
+	}
+	for entry, name := range renames {
+		entry.Filename = name
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRenameFile(t *testing.T) {
+	v := newTestVault(t)
+	first := addTestFile(t, v, "a", randomData(10))
+	data := randomData(20)
+	second := addTestFile(t, v, "a", data)
+	if err := v.Rename("a", "dir/b"); err != nil {
+		t.Fatal(err)
+	}
+	if first.Filename != "dir/b" || second.Filename != "dir/b" || first.Version != 1 || second.Version != 2 {
+		t.Fatalf("renamed to %s@%d and %s@%d", first.Filename, first.Version, second.Filename, second.Version)
+	}
+	if v.resolveFile("a") != nil {
+		t.Error("the old name still resolves")
+	}
+	if got := readTestEntry(t, v, v.resolveFile("dir/b")); !bytes.Equal(got, data) {
+		t.Error("the renamed file has the wrong contents")
+	}
+}
+
+func TestRenameDir(t *testing.T) {
+	v := newTestVault(t)
+	x := addTestFile(t, v, "docs/x", randomData(10))
+	y := addTestFile(t, v, "docs/sub/y", randomData(20))
+	other := addTestFile(t, v, "docs2/z", randomData(30))
+	if err := v.Rename("docs", "archive/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if x.Filename != "archive/docs/x" || y.Filename != "archive/docs/sub/y" || other.Filename != "docs2/z" {
+		t.Errorf("renamed to '%s', '%s' and '%s'", x.Filename, y.Filename, other.Filename)
+	}
+	if err := v.Rename("archive", "archive/old"); err == nil {
+		t.Error("directory moved into itself")
+	}
+	if err := v.Rename("missing", "new"); err == nil {
+		t.Error("missing file renamed")
+	}
+}
+
+func TestRenameConflicts(t *testing.T) {
+	v := newTestVault(t)
+	addTestFile(t, v, "a", randomData(10))
+	addTestFile(t, v, "b", randomData(20))
+	addTestFile(t, v, "dir/c", randomData(30))
+	for _, rename := range [][2]string{
+		{"a", "b"},       // an existing file
+		{"a", "dir"},     // an existing directory
+		{"a", "b/a"},     // under a file
+		{"dir", "b"},     // a directory onto a file
+		{"dir/c", "a/c"}, // under a file
+	} {
+		if err := v.Rename(rename[0], rename[1]); err == nil {
+			t.Errorf("'%s' renamed to '%s'", rename[0], rename[1])
+		}
+	}
+	if v.resolveFile("a") == nil || v.resolveFile("b") == nil || v.resolveFile("dir/c") == nil {
+		t.Error("a failed rename changed the entries")
+	}
+}
+
+func TestRenameOntoTrash(t *testing.T) {
+	v := newTestVault(t)
+	var trashed []*AESVaultEntry
+	for i := 0; i < 2; i++ {
+		trashed = append(trashed, addTestFile(t, v, "b", randomData(10+i)))
+	}
+	if err := v.RemoveEntries(trashed); err != nil {
+		t.Fatal(err)
+	}
+	first := addTestFile(t, v, "a", randomData(20))
+	second := addTestFile(t, v, "a", randomData(30))
+	if err := v.Rename("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 3 || second.Version != 4 {
+		t.Fatalf("renamed versions numbered %d and %d, want 3 and 4", first.Version, second.Version)
+	}
+	restored, err := v.RestoreTrash("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, _ := v.History("b")
+	if len(restored) != 2 || len(history) != 4 {
+		t.Fatalf("restored %d versions, %d in total", len(restored), len(history))
+	}
+	if _, latest := v.lookupFile("b"); latest != second {
+		t.Error("the latest version is not the renamed one")
+	}
+}
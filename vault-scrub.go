@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Scrubbing verifies a part of the vault at a time (e.g. every night), the
+// entries which have gone the longest without being verified first, so that
+// the whole vault is verified over a number of runs.
+// The result of the last test of each entry is recorded in the entry, for the
+// health report.
+
+// VerifyRecord records the last integrity test of the ciphertext of an entry.
+type VerifyRecord struct {
+	Time   time.Time
+	Status string
+	Reason string
+	// Damage is set if the ciphertext has parity data (see EntryIntegrity)
+	Damage string
+	// Repaired is the time at which the ciphertext was last repaired with its
+	// parity data (zero if it never was)
+	Repaired time.Time
+}
+
+// recordVerification records the results of integrity tests completed at the
+// given time in their entries.
+func (v *AESVault) recordVerification(results []EntryIntegrity, at time.Time) {
+	if v.readOnly {
+		return
+	}
+	for _, r := range results {
+		record := &VerifyRecord{Time: at, Status: r.Status, Reason: r.Reason, Damage: r.Damage}
+		if r.Entry.Verified != nil {
+			record.Repaired = r.Entry.Verified.Repaired
+		}
+		r.Entry.Verified = record
+	}
+}
+
+// recordRepairs records the repaired and unrecoverable ciphertexts of a repair
+// completed at the given time in the entries of the vault which refer to them.
+func (v *AESVault) recordRepairs(results []RepairResult, at time.Time) {
+	if v.readOnly {
+		return
+	}
+	records := make(map[string]VerifyRecord)
+	for _, r := range results {
+		switch {
+		case r.Err != nil || r.Scan.Intact():
+		case r.Repaired:
+			records[r.Entry.EncryptedName] = VerifyRecord{Time: at, Status: statusPassed, Repaired: at}
+		default:
+			records[r.Entry.EncryptedName] = VerifyRecord{
+				Time:   at,
+				Status: statusFailed,
+				Reason: fmt.Sprintf("unrecoverable damage (%s)", describeDamage(r.Scan.Damaged, r.Scan.BadSize)),
+				Damage: damageUnrecoverable,
+			}
+		}
+	}
+	for _, entry := range v.Files {
+		record, ok := records[entry.EncryptedName]
+		if !ok {
+			continue
+		}
+		if record.Repaired.IsZero() && entry.Verified != nil {
+			record.Repaired = entry.Verified.Repaired
+		}
+		entry.Verified = &record
+	}
+}
+
+// ScrubOptions configure a scrub of the vault.
+type ScrubOptions struct {
+	// MaxTime and MaxBytes bound the time taken by the scrub and the total
+	// size of the ciphertexts it tests; 0 means no bound.
+	// At least one entry is tested regardless of MaxBytes.
+	MaxTime  time.Duration
+	MaxBytes int64
+	// Concurrency and Deep are as in IntegrityOptions
+	Concurrency int
+	Deep        bool
+	// Repair repairs the damaged ciphertexts which are repairable with their
+	// parity data
+	Repair bool
+	// OnResult is as in IntegrityOptions
+	OnResult func(result EntryIntegrity, done, total int)
+}
+
+// ScrubResult is the result of a scrub of the vault.
+type ScrubResult struct {
+	// results of the integrity tests, in the order in which the entries were
+	// selected
+	Results []EntryIntegrity
+	// total size of the tested ciphertexts
+	Bytes int64
+	// Repairs are the results of the repairs of damaged ciphertexts
+	Repairs []RepairResult
+	// Remaining is the number of entries which were not tested, because of
+	// the bounds of the scrub
+	Remaining int
+}
+
+// Scrub tests the integrity of the entries of the vault which have gone the
+// longest without being tested (never tested entries first), until the bounds
+// of opts are reached, and records the results in the entries.
+// If ctx is cancelled, the results of the completed tests are recorded and
+// returned along with the context's error.
+func (v *AESVault) Scrub(ctx context.Context, opts ScrubOptions) (*ScrubResult, error) {
+	entries := scrubOrder(v.Files)
+	selected := entries
+	if opts.MaxBytes > 0 {
+		var size int64
+		for i, entry := range entries {
+			size += entry.Size + entry.Padding
+			if size > opts.MaxBytes && i > 0 {
+				selected = entries[:i]
+				break
+			}
+		}
+	}
+	tctx := ctx
+	if opts.MaxTime > 0 {
+		var cancel context.CancelFunc
+		tctx, cancel = context.WithTimeout(ctx, opts.MaxTime)
+		defer cancel()
+	}
+	results, err := v.Verify(tctx, IntegrityOptions{
+		Concurrency: opts.Concurrency,
+		Deep:        opts.Deep,
+		Entries:     selected,
+		OnResult:    opts.OnResult,
+	})
+	v.recordVerification(results, time.Now())
+	scrub := &ScrubResult{Results: results, Remaining: len(entries) - len(results)}
+	for _, r := range results {
+		scrub.Bytes += r.Entry.Size + r.Entry.Padding
+	}
+	if err != nil && ctx.Err() != nil {
+		// interrupted, rather than out of time
+		return scrub, ctx.Err()
+	}
+	if !opts.Repair {
+		return scrub, nil
+	}
+	for _, r := range results {
+		if r.Damage != damageRepairable {
+			continue
+		}
+		scan, err := v.CheckParity(ctx, r.Entry, true)
+		if ctx.Err() != nil {
+			break
+		}
+		scrub.Repairs = append(scrub.Repairs, RepairResult{Entry: r.Entry, Scan: scan, Err: err, Repaired: err == nil && scan.Repairable()})
+	}
+	v.recordRepairs(scrub.Repairs, time.Now())
+	return scrub, ctx.Err()
+}
+
+// scrubOrder returns the entries in the order in which they are scrubbed:
+// the entries which were never tested (oldest first), then the others, least
+// recently tested first.
+func scrubOrder(entries []*AESVaultEntry) []*AESVaultEntry {
+	ordered := append([]*AESVaultEntry(nil), entries...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i].Verified, ordered[j].Verified
+		switch {
+		case a == nil && b == nil:
+			return ordered[i].Added.Before(ordered[j].Added)
+		case a == nil || b == nil:
+			return a == nil
+		}
+		return a.Time.Before(b.Time)
+	})
+	return ordered
+}
+
+// HealthReport summarizes the recorded integrity tests of the entries of the
+// vault.
+type HealthReport struct {
+	Entries int
+	// StaleAfter is the time after which a test is stale
+	StaleAfter time.Duration
+	// NeverVerified are the entries which were never tested
+	NeverVerified []*AESVaultEntry
+	// Stale are the entries which were not tested within StaleAfter (for
+	// entries which were never tested, since they were added)
+	Stale []*AESVaultEntry
+	// Failed are the entries whose last test did not pass
+	Failed []*AESVaultEntry
+	// Repaired are the entries whose ciphertexts were repaired
+	Repaired []*AESVaultEntry
+}
+
+// Healthy reports whether all the entries passed their last test, and were
+// tested recently enough.
+func (h *HealthReport) Healthy() bool {
+	return len(h.Failed) == 0 && len(h.Stale) == 0
+}
+
+// Health reports on the recorded integrity tests of the entries of the vault,
+// at the time now.
+func (v *AESVault) Health(staleAfter time.Duration, now time.Time) *HealthReport {
+	report := &HealthReport{Entries: len(v.Files), StaleAfter: staleAfter}
+	cutoff := now.Add(-staleAfter)
+	for _, entry := range v.Files {
+		record := entry.Verified
+		if record == nil {
+			report.NeverVerified = append(report.NeverVerified, entry)
+			if entry.Added.Before(cutoff) {
+				report.Stale = append(report.Stale, entry)
+			}
+			continue
+		}
+		if record.Time.Before(cutoff) {
+			report.Stale = append(report.Stale, entry)
+		}
+		if record.Status != statusPassed {
+			report.Failed = append(report.Failed, entry)
+		}
+		if !record.Repaired.IsZero() {
+			report.Repaired = append(report.Repaired, entry)
+		}
+	}
+	return report
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// addScrubTestFiles adds n files of 1000 bytes (1008 byte ciphertexts) to the
+// vault, returning their entries.
+func addScrubTestFiles(t *testing.T, v *AESVault, n int) []*AESVaultEntry {
+	t.Helper()
+	var entries []*AESVaultEntry
+	for i := 0; i < n; i++ {
+		entries = append(entries, addTestFile(t, v, string(rune('a'+i)), randomData(1000)))
+	}
+	return entries
+}
+
+// scrubTested returns the names of the files tested by a scrub, in order.
+func scrubTested(scrub *ScrubResult) []string {
+	var names []string
+	for _, r := range scrub.Results {
+		names = append(names, r.Entry.Filename)
+	}
+	return names
+}
+
+func TestScrubMaxBytes(t *testing.T) {
+	v := newTestVault(t)
+	addScrubTestFiles(t, v, 4)
+	for _, test := range []struct {
+		maxBytes  int64
+		tested    []string
+		remaining int
+	}{
+		// never tested entries first, in the order they were added
+		{2100, []string{"a", "b"}, 2},
+		{2100, []string{"c", "d"}, 2},
+		// then the least recently tested
+		{3100, []string{"a", "b", "c"}, 1},
+		// at least one entry is tested
+		{10, []string{"d"}, 3},
+		{0, []string{"a", "b", "c", "d"}, 0},
+	} {
+		scrub, err := v.Scrub(context.Background(), ScrubOptions{MaxBytes: test.maxBytes, Concurrency: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tested := scrubTested(scrub); !equalStrings(tested, test.tested) || scrub.Remaining != test.remaining {
+			t.Errorf("max %d bytes: tested %v with %d remaining, want %v with %d", test.maxBytes, tested, scrub.Remaining, test.tested, test.remaining)
+		}
+		if scrub.Bytes != int64(len(scrub.Results))*1008 {
+			t.Errorf("max %d bytes: %d bytes tested", test.maxBytes, scrub.Bytes)
+		}
+		// the tests are ordered by time
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScrubMaxTime(t *testing.T) {
+	v := newTestVault(t)
+	entries := addScrubTestFiles(t, v, 4)
+	scrub, err := v.Scrub(context.Background(), ScrubOptions{
+		MaxTime:     50 * time.Millisecond,
+		Concurrency: 1,
+		// the first test outlasts the scrub
+		OnResult: func(EntryIntegrity, int, int) { time.Sleep(100 * time.Millisecond) },
+	})
+	if err != nil {
+		t.Fatalf("running out of time failed the scrub: %v", err)
+	}
+	if tested := scrubTested(scrub); !equalStrings(tested, []string{"a"}) || scrub.Remaining != 3 {
+		t.Fatalf("tested %v with %d remaining, want [a] with 3", tested, scrub.Remaining)
+	}
+	if entries[0].Verified == nil || entries[1].Verified != nil {
+		t.Error("the tests were not recorded in their entries")
+	}
+
+	// a cancelled scrub records the completed tests, and fails
+	ctx, cancel := context.WithCancel(context.Background())
+	scrub, err = v.Scrub(ctx, ScrubOptions{
+		Concurrency: 1,
+		OnResult:    func(_ EntryIntegrity, done, _ int) { cancel() },
+	})
+	if err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if tested := scrubTested(scrub); !equalStrings(tested, []string{"b"}) || entries[1].Verified == nil {
+		t.Errorf("tested %v, want [b]", tested)
+	}
+}
+
+func TestScrubRepair(t *testing.T) {
+	v := newTestVault(t)
+	if _, err := v.SetParity(25); err != nil {
+		t.Fatal(err)
+	}
+	entry := addTestFile(t, v, "x", randomData(3*parityShardSize))
+	corrupt(t, v.ciphertextPath(entry), 1)
+	scrub, err := v.Scrub(context.Background(), ScrubOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scrub.Repairs) != 1 || !scrub.Repairs[0].Repaired {
+		t.Fatalf("%d repairs", len(scrub.Repairs))
+	}
+	if r := v.entryIntegrity(context.Background(), entry); r.Status != statusPassed {
+		t.Errorf("repaired ciphertext %s: %s", r.Status, r.Reason)
+	}
+	if entry.Verified == nil || entry.Verified.Status != statusPassed || entry.Verified.Repaired.IsZero() {
+		t.Errorf("repair recorded as %+v", entry.Verified)
+	}
+
+	v = reopenTestVault(t, v)
+	report := v.Health(time.Hour, time.Now())
+	if len(report.Repaired) != 1 || !report.Healthy() {
+		t.Errorf("health report %+v", report)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	v := newTestVault(t)
+	entries := addScrubTestFiles(t, v, 4)
+	now := time.Now()
+	entries[0].Verified = &VerifyRecord{Time: now.Add(-time.Minute), Status: statusPassed}
+	entries[1].Verified = &VerifyRecord{Time: now.Add(-2 * time.Hour), Status: statusPassed}
+	entries[2].Verified = &VerifyRecord{Time: now.Add(-time.Minute), Status: statusFailed}
+	entries[3].Added = now.Add(-2 * time.Hour)
+
+	report := v.Health(time.Hour, now)
+	for _, test := range []struct {
+		name string
+		got  []*AESVaultEntry
+		want []string
+	}{
+		{"never verified", report.NeverVerified, []string{"d"}},
+		{"stale", report.Stale, []string{"b", "d"}},
+		{"failed", report.Failed, []string{"c"}},
+		{"repaired", report.Repaired, nil},
+	} {
+		var names []string
+		for _, entry := range test.got {
+			names = append(names, entry.Filename)
+		}
+		if !equalStrings(names, test.want) {
+			t.Errorf("%s: %v, want %v", test.name, names, test.want)
+		}
+	}
+	if report.Healthy() || report.Entries != 4 {
+		t.Errorf("%d entries reported healthy", report.Entries)
+	}
+	if report := v.Health(3*time.Hour, now); len(report.Stale) != 0 || report.Healthy() {
+		t.Error("failed entries reported healthy")
+	}
+}
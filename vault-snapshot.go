@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Snapshot is an immutable, point-in-time copy of the vault's file entries.
+// Since ciphertexts are never modified once written, a snapshot shares the
+// ciphertexts of the files which have not changed since it was created; a
+// ciphertext is only deleted once no entry or snapshot refers to it.
+type Snapshot struct {
+	Label   string
+	Created time.Time
+	Files   []*AESVaultEntry
+}
+
+// lookupSnapshot returns the snapshot with the given label, along with its
+// index in the vault's snapshots.
+func (v *AESVault) lookupSnapshot(label string) (int, *Snapshot) {
+	for i, snapshot := range v.Snapshots {
+		if snapshot.Label == label {
+			return i, snapshot
+		}
+	}
+	return -1, nil
+}
+
+// cloneEntries returns a deep copy of entries.
+func cloneEntries(entries []*AESVaultEntry) []*AESVaultEntry {
+	clones := make([]*AESVaultEntry, len(entries))
+	for i, entry := range entries {
+		clones[i] = entry.clone()
+	}
+	return clones
+}
+
+// CreateSnapshot records the current state of the vault's entries as a
+// snapshot with the given (unique) label.
+func (v *AESVault) CreateSnapshot(label string) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	if label == "" {
+		return fmt.Errorf("snapshot label cannot be empty")
+	}
+	if _, snapshot := v.lookupSnapshot(label); snapshot != nil {
+		return fmt.Errorf("snapshot '%s' already exists", label)
+	}
+	v.Snapshots = append(v.Snapshots, &Snapshot{
+		Label:   label,
+		Created: time.Now(),
+		Files:   cloneEntries(v.Files),
+	})
+	return nil
+}
+
+// DeleteSnapshot deletes the snapshot with the given label.
+// The entries whose ciphertexts were only referenced by the snapshot (see
+// SnapshotRemovals) are moved to the trash if it is enabled, or else deleted
+// along with their ciphertexts.
+func (v *AESVault) DeleteSnapshot(label string) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	removed, err := v.SnapshotRemovals(label)
+	if err != nil {
+		return err
+	}
+	idx, _ := v.lookupSnapshot(label)
+	v.Snapshots = append(v.Snapshots[:idx], v.Snapshots[idx+1:]...)
+	if v.TrashDays > 0 {
+		v.trashEntries(removed)
+		return nil
+	}
+	return v.deleteUnreferenced(removed)
+}
+
+// SnapshotRemovals returns the entries of the snapshot with the given label
+// whose ciphertexts are not referenced by the vault's entries, trash or other
+// snapshots, which are removed when the snapshot is deleted.
+func (v *AESVault) SnapshotRemovals(label string) ([]*AESVaultEntry, error) {
+	idx, snapshot := v.lookupSnapshot(label)
+	if snapshot == nil {
+		return nil, fmt.Errorf("no snapshot '%s' in vault", label)
+	}
+	others := &AESVault{
+		Files:     v.Files,
+		Trash:     v.Trash,
+		Snapshots: append(append([]*Snapshot(nil), v.Snapshots[:idx]...), v.Snapshots[idx+1:]...),
+	}
+	refs := others.ciphertextRefs()
+	var removed []*AESVaultEntry
+	for _, entry := range snapshot.Files {
+		if !refs[entry.EncryptedName] {
+			refs[entry.EncryptedName] = true
+			removed = append(removed, entry)
+		}
+	}
+	return removed, nil
+}
+
+// SnapshotView returns a read-only vault containing the entries of the
+// snapshot with the given label, which can be used to list and retrieve the
+// files as they were when the snapshot was created.
+func (v *AESVault) SnapshotView(label string) (*AESVault, error) {
+	_, snapshot := v.lookupSnapshot(label)
+	if snapshot == nil {
+		return nil, fmt.Errorf("no snapshot '%s' in vault", label)
+	}
+	return &AESVault{
+		dirName:     v.dirName,
+		Name:        v.Name,
+		Encryption:  v.Encryption,
+		key:         v.key,
+		meta:        v.meta,
+		Files:       snapshot.Files,
+		MaxVersions: v.MaxVersions,
+		readOnly:    true,
+	}, nil
+}
+
+// SnapshotRemovalEffects describes, for each of the entries removed with the
+// snapshot (see SnapshotRemovals), what deleting the snapshot would do.
+func (v *AESVault) SnapshotRemovalEffects(entries []*AESVaultEntry) []string {
+	effects := make([]string, len(entries))
+	for i := range entries {
+		if v.TrashDays > 0 {
+			effects[i] = fmt.Sprintf("moved to the trash for %d days", v.TrashDays)
+		} else {
+			effects[i] = "deleted, with its ciphertext"
+		}
+	}
+	return effects
+}
+
+// Rollback restores the vault's entries to the state recorded in the snapshot
+// with the given label.
+// The snapshot itself is kept, and the entries which are not in it (see
+// RollbackRemovals) are removed as by RemoveEntries, going to the trash if it
+// is enabled.
+// Entries in the trash which the rollback brings back are dropped from it.
+func (v *AESVault) Rollback(label string) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	removed, err := v.RollbackRemovals(label)
+	if err != nil {
+		return err
+	}
+	if err := v.RemoveEntries(removed); err != nil {
+		return err
+	}
+	_, snapshot := v.lookupSnapshot(label)
+	v.Files = cloneEntries(snapshot.Files)
+	restored := snapshotKeys(snapshot)
+	var kept []*TrashItem
+	for _, item := range v.Trash {
+		if item.Entry == nil || !restored[snapshotKey(item.Entry)] {
+			kept = append(kept, item)
+		}
+	}
+	v.Trash = kept
+	return nil
+}
+
+// RollbackRemovals returns the entries of the vault which are not in the
+// snapshot with the given label (the versions added or renamed since it was
+// created), which are removed by a rollback to the snapshot.
+func (v *AESVault) RollbackRemovals(label string) ([]*AESVaultEntry, error) {
+	_, snapshot := v.lookupSnapshot(label)
+	if snapshot == nil {
+		return nil, fmt.Errorf("no snapshot '%s' in vault", label)
+	}
+	recorded := snapshotKeys(snapshot)
+	var removed []*AESVaultEntry
+	for _, entry := range v.Files {
+		if !recorded[snapshotKey(entry)] {
+			removed = append(removed, entry)
+		}
+	}
+	return removed, nil
+}
+
+// snapshotKey identifies the version of a file recorded by entry, along with its
+// ciphertext, regardless of the entry's annotations.
+func snapshotKey(entry *AESVaultEntry) string {
+	return fmt.Sprintf("%s@%d/%s", entry.Filename, entry.Version, entry.EncryptedName)
+}
+
+// snapshotKeys returns the set of keys (see snapshotKey) of the entries of the
+// snapshot.
+func snapshotKeys(snapshot *Snapshot) map[string]bool {
+	keys := make(map[string]bool)
+	for _, entry := range snapshot.Files {
+		keys[snapshotKey(entry)] = true
+	}
+	return keys
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestSnapshotView(t *testing.T) {
+	v := newTestVault(t)
+	old := randomData(100)
+	entry := addTestFile(t, v, "x", old)
+	if err := v.CreateSnapshot("s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.CreateSnapshot("s"); err == nil {
+		t.Error("duplicate snapshot label accepted")
+	}
+	addTestFile(t, v, "x", randomData(200))
+	entry.Tags = []string{"changed"}
+	view, err := v.SnapshotView("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapped := view.resolveFile("x")
+	if snapped == nil || snapped.Version != 1 || len(snapped.Tags) != 0 {
+		t.Fatal("the snapshot does not record the state of the vault")
+	}
+	if got := readTestEntry(t, view, snapped); !bytes.Equal(got, old) {
+		t.Error("the snapshot has the wrong contents")
+	}
+	if err := view.CreateSnapshot("t"); err != errReadOnly {
+		t.Errorf("snapshot view is not read-only: %v", err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	v := newTestVault(t)
+	kept := addTestFile(t, v, "x", randomData(100))
+	removed := addTestFile(t, v, "y", randomData(10))
+	if err := v.CreateSnapshot("s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RemoveEntries([]*AESVaultEntry{removed}); err != nil {
+		t.Fatal(err)
+	}
+	data := randomData(200)
+	added := addTestFile(t, v, "x", data)
+	addTestFile(t, v, "z", randomData(20))
+	removals, err := v.RollbackRemovals("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removals) != 2 || removals[0] != added {
+		t.Fatalf("rollback would remove %d entries, want x@2 and z@1", len(removals))
+	}
+	if err := v.Rollback("s"); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Files) != 2 || v.Files[0].EncryptedName != kept.EncryptedName || v.Files[1].EncryptedName != removed.EncryptedName {
+		t.Fatalf("rolled back to %d entries", len(v.Files))
+	}
+	// the versions added since the snapshot are in the trash, and y, which the
+	// rollback restored, is no longer in it
+	if len(v.Trash) != 2 || v.Trash[0].Entry != added {
+		t.Fatalf("%d items in the trash, want x@2 and z@1", len(v.Trash))
+	}
+	if _, err := v.RestoreTrash("x"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestEntry(t, v, v.resolveFile("x")); !bytes.Equal(got, data) {
+		t.Error("the restored version has the wrong contents")
+	}
+}
+
+func TestRollbackNoTrash(t *testing.T) {
+	v := newTestVault(t)
+	v.TrashDays = 0
+	addTestFile(t, v, "x", randomData(100))
+	if err := v.CreateSnapshot("s"); err != nil {
+		t.Fatal(err)
+	}
+	added := addTestFile(t, v, "x", randomData(200))
+	if err := v.Rollback("s"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(v.ciphertextPath(added)); !os.IsNotExist(err) {
+		t.Errorf("ciphertext of the removed version not deleted: %v", err)
+	}
+	if err := v.Rollback("t"); err == nil {
+		t.Error("rollback to a missing snapshot")
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	v := newTestVault(t)
+	shared := addTestFile(t, v, "x", randomData(100))
+	only := addTestFile(t, v, "y", randomData(10))
+	for _, label := range []string{"s", "t"} {
+		if err := v.CreateSnapshot(label); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v.TrashDays = 0
+	if err := v.RemoveEntries([]*AESVaultEntry{only}); err != nil {
+		t.Fatal(err)
+	}
+	v.TrashDays = DefaultTrashDays
+	// y is still referenced by t
+	if removals, err := v.SnapshotRemovals("s"); err != nil || len(removals) != 0 {
+		t.Fatalf("deleting 's' would remove %d entries (%v)", len(removals), err)
+	}
+	if err := v.DeleteSnapshot("s"); err != nil {
+		t.Fatal(err)
+	}
+	removals, err := v.SnapshotRemovals("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removals) != 1 || removals[0].EncryptedName != only.EncryptedName {
+		t.Fatalf("deleting 't' would remove %d entries, want y@1", len(removals))
+	}
+	if err := v.DeleteSnapshot("t"); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Snapshots) != 0 || len(v.Trash) != 1 || v.Trash[0].Entry.EncryptedName != only.EncryptedName {
+		t.Fatalf("%d snapshots left, %d items in the trash", len(v.Snapshots), len(v.Trash))
+	}
+	if _, err := v.EmptyTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(v.ciphertextPath(only)); !os.IsNotExist(err) {
+		t.Errorf("ciphertext of y not deleted: %v", err)
+	}
+	if _, err := os.Stat(v.ciphertextPath(shared)); err != nil {
+		t.Errorf("ciphertext of x: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// StreamFile decrypts the file with the given name and writes its contents to
+// w, without writing the plaintext to disk.
+// The latest version of the file is decrypted, unless a version is specified
+// using the "name@version" syntax.
+// Note that the contents are written before the ciphertext is authenticated, so
+// a non-nil error means that the contents written to w must not be trusted.
+func (v *AESVault) StreamFile(name string, w io.Writer) error {
+	entry := v.resolveFile(name)
+	if entry == nil {
+		return fmt.Errorf("no entry for '%s' in vault", name)
+	}
+	return v.readEntry(entry, w)
+}
+
+// sniffLen is the length of the sample used to detect binary files.
+const sniffLen = 512
+
+// binaryGuard is a writer which refuses to pass on binary data to the
+// underlying writer, as detected from the first sniffLen bytes written to it.
+// Flush must be called once all the data has been written.
+type binaryGuard struct {
+	w       io.Writer
+	sample  []byte
+	checked bool
+	// Refused is set if the data was found to be binary
+	Refused bool
+}
+
+func newBinaryGuard(w io.Writer) *binaryGuard {
+	return &binaryGuard{w: w}
+}
+
+var errBinary = fmt.Errorf("file appears to be binary")
+
+func (g *binaryGuard) Write(p []byte) (int, error) {
+	if g.checked {
+		if g.Refused {
+			return 0, errBinary
+		}
+		return g.w.Write(p)
+	}
+	g.sample = append(g.sample, p...)
+	if len(g.sample) < sniffLen {
+		return len(p), nil
+	}
+	if err := g.Flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush checks the data written so far, if it has not been checked yet, and
+// passes it on.
+func (g *binaryGuard) Flush() error {
+	if g.checked {
+		return nil
+	}
+	g.checked = true
+	if looksBinary(g.sample) {
+		g.Refused = true
+		return errBinary
+	}
+	_, err := g.w.Write(g.sample)
+	g.sample = nil
+	return err
+}
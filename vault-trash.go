@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Removed entries (and unlinked ciphertexts deleted by the gc command) are kept
+// in the vault's trash for TrashDays days, during which they can be restored.
+// Like the entries of snapshots, the entries in the trash keep their
+// ciphertexts in the vault directory; these are deleted when the entries
+// expire (when the vault is saved) or the trash is emptied.
+
+// DefaultTrashDays is the trash retention period of new vaults.
+const DefaultTrashDays = 30
+
+// TrashItem is an entry, or an unlinked ciphertext, in the trash.
+type TrashItem struct {
+	// Entry is the removed entry; nil for unlinked ciphertexts
+	Entry *AESVaultEntry
+	// Ciphertext is the name of an unlinked ciphertext in the vault directory
+	Ciphertext string
+	Deleted    time.Time
+}
+
+// Name returns a description of the item: the "name@version" reference of its
+// entry, or the name of its ciphertext.
+func (t *TrashItem) Name() string {
+	if t.Entry != nil {
+		return fmt.Sprintf("%s@%d", t.Entry.Filename, t.Entry.Version)
+	}
+	return t.Ciphertext
+}
+
+// Expires returns the time at which the item is deleted, given the retention
+// period of the vault.
+func (t *TrashItem) Expires(days int) time.Time {
+	return t.Deleted.AddDate(0, 0, days)
+}
+
+// trashEntries moves the removed entries to the trash.
+func (v *AESVault) trashEntries(entries []*AESVaultEntry) {
+	now := time.Now()
+	for _, entry := range entries {
+		v.Trash = append(v.Trash, &TrashItem{Entry: entry, Deleted: now})
+	}
+}
+
+// SetTrashDays sets the number of days that removed entries are kept in the
+// trash; 0 disables the trash, emptying it.
+func (v *AESVault) SetTrashDays(days int) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	if days < 0 {
+		return fmt.Errorf("invalid number of days %d", days)
+	}
+	v.TrashDays = days
+	return v.expireTrash(time.Now())
+}
+
+// expireTrash deletes the items in the trash whose retention period ended
+// before now.
+func (v *AESVault) expireTrash(now time.Time) error {
+	return v.deleteTrash(func(item *TrashItem) bool {
+		return v.TrashDays == 0 || !item.Expires(v.TrashDays).After(now)
+	})
+}
+
+// EmptyTrash deletes all the items in the trash, returning them.
+func (v *AESVault) EmptyTrash() ([]*TrashItem, error) {
+	if v.readOnly {
+		return nil, errReadOnly
+	}
+	items := v.Trash
+	return items, v.deleteTrash(func(*TrashItem) bool { return true })
+}
+
+// deleteTrash removes the items of the trash for which remove returns true,
+// deleting their ciphertexts unless they are still referenced.
+func (v *AESVault) deleteTrash(remove func(item *TrashItem) bool) error {
+	var kept, removed []*TrashItem
+	for _, item := range v.Trash {
+		if remove(item) {
+			removed = append(removed, item)
+		} else {
+			kept = append(kept, item)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	v.Trash = kept
+	var entries []*AESVaultEntry
+	refs := v.ciphertextRefs()
+	for _, item := range removed {
+		if item.Entry != nil {
+			entries = append(entries, item.Entry)
+		} else if !refs[item.Ciphertext] {
+			if err := os.Remove(filepath.Join(v.dirName, item.Ciphertext)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to delete encrypted file: %s", err.Error())
+			}
+		}
+	}
+	return v.deleteUnreferenced(entries)
+}
+
+// RestoreTrash restores the entries in the trash for the file with the given
+// name (all of its versions), or for a single version of it using the
+// "name@version" syntax, returning the restored entries.
+// Restoring fails if the vault already has an entry with the same version.
+func (v *AESVault) RestoreTrash(ref string) ([]*AESVaultEntry, error) {
+	if v.readOnly {
+		return nil, errReadOnly
+	}
+	match := func(e *AESVaultEntry) bool { return e.Filename == ref }
+	if !v.inTrash(match) {
+		name, version := splitVersion(ref)
+		match = func(e *AESVaultEntry) bool { return e.Filename == name && e.Version == version }
+		if !v.inTrash(match) {
+			return nil, fmt.Errorf("no entry for '%s' in the trash", ref)
+		}
+	}
+	var kept []*TrashItem
+	var restored []*AESVaultEntry
+	exists := make(map[string]bool)
+	for _, entry := range v.Files {
+		exists[fmt.Sprintf("%s@%d", entry.Filename, entry.Version)] = true
+	}
+	for _, item := range v.Trash {
+		if item.Entry == nil || !match(item.Entry) {
+			kept = append(kept, item)
+			continue
+		}
+		if exists[item.Name()] {
+			return nil, fmt.Errorf("'%s' already exists in the vault", item.Name())
+		}
+		exists[item.Name()] = true
+		restored = append(restored, item.Entry)
+	}
+	v.Trash = kept
+	v.Files = append(v.Files, restored...)
+	return restored, nil
+}
+
+// inTrash reports whether the trash has an entry for which match returns true.
+func (v *AESVault) inTrash(match func(e *AESVaultEntry) bool) bool {
+	for _, item := range v.Trash {
+		if item.Entry != nil && match(item.Entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readTestEntry decrypts entry, returning its contents.
+func readTestEntry(t *testing.T, v *AESVault, entry *AESVaultEntry) []byte {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "out")
+	if err := v.retrieveEntry(entry, out); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRestoreAfterReadding(t *testing.T) {
+	v := newTestVault(t)
+	if v.TrashDays == 0 {
+		t.Fatal("the trash of new vaults is disabled")
+	}
+	old, data := randomData(100), randomData(200)
+	removed := addTestFile(t, v, "x", old)
+	if err := v.RemoveEntries([]*AESVaultEntry{removed}); err != nil {
+		t.Fatal(err)
+	}
+	added := addTestFile(t, v, "x", data)
+	if added.Version <= removed.Version {
+		t.Fatalf("re-added file has version %d, removed one %d", added.Version, removed.Version)
+	}
+	restored, err := v.RestoreTrash("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || restored[0] != removed || len(v.Trash) != 0 {
+		t.Fatalf("restored %d entries, %d left in the trash", len(restored), len(v.Trash))
+	}
+	if _, latest := v.lookupFile("x"); latest != added {
+		t.Fatalf("latest version is %d, want %d", latest.Version, added.Version)
+	}
+	if got := readTestEntry(t, v, removed); !bytes.Equal(got, old) {
+		t.Error("restored version has the wrong contents")
+	}
+	if got := readTestEntry(t, v, added); !bytes.Equal(got, data) {
+		t.Error("latest version has the wrong contents")
+	}
+}
+
+func TestVersionCountsSnapshots(t *testing.T) {
+	v := newTestVault(t)
+	v.TrashDays = 0
+	entry := addTestFile(t, v, "x", randomData(10))
+	if err := v.CreateSnapshot("s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RemoveEntries([]*AESVaultEntry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	if added := addTestFile(t, v, "x", randomData(20)); added.Version != entry.Version+1 {
+		t.Errorf("re-added file has version %d, want %d", added.Version, entry.Version+1)
+	}
+}
+
+func TestExpireTrash(t *testing.T) {
+	v := newTestVault(t)
+	kept := addTestFile(t, v, "kept", randomData(10))
+	expired := addTestFile(t, v, "expired", randomData(20))
+	if err := v.RemoveEntries([]*AESVaultEntry{kept, expired}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, item := range v.Trash {
+		if item.Entry == expired {
+			item.Deleted = now.AddDate(0, 0, -v.TrashDays)
+		}
+	}
+	if err := v.expireTrash(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Trash) != 1 || v.Trash[0].Entry != kept {
+		t.Fatalf("%d items left in the trash, want only 'kept'", len(v.Trash))
+	}
+	if _, err := os.Stat(v.ciphertextPath(expired)); !os.IsNotExist(err) {
+		t.Errorf("ciphertext of the expired entry was not deleted: %v", err)
+	}
+	if _, err := os.Stat(v.ciphertextPath(kept)); err != nil {
+		t.Errorf("ciphertext of the kept entry: %v", err)
+	}
+}
+
+func TestEmptyTrash(t *testing.T) {
+	v := newTestVault(t)
+	shared := randomData(30)
+	entry := addTestFile(t, v, "x", shared)
+	if err := v.CreateSnapshot("s"); err != nil {
+		t.Fatal(err)
+	}
+	other := addTestFile(t, v, "y", randomData(40))
+	if err := v.RemoveEntries([]*AESVaultEntry{entry, other}); err != nil {
+		t.Fatal(err)
+	}
+	items, err := v.EmptyTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || len(v.Trash) != 0 {
+		t.Fatalf("emptied %d items, %d left", len(items), len(v.Trash))
+	}
+	// the snapshot still refers to the ciphertext of x
+	if _, err := os.Stat(v.ciphertextPath(entry)); err != nil {
+		t.Errorf("ciphertext referenced by a snapshot: %v", err)
+	}
+	if _, err := os.Stat(v.ciphertextPath(other)); !os.IsNotExist(err) {
+		t.Errorf("ciphertext of y was not deleted: %v", err)
+	}
+}
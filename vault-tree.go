@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Entries in the vault are named by slash-separated paths which are relative to
+// the root of the vault (e.g. "documents/taxes/2020.pdf").
+// This allows files with the same base name, but from different directories,
+// to be stored in the same vault.
+
+// cleanEntryName normalizes a vault path, removing redundant separators and
+// any leading "/" or "./".
+func cleanEntryName(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+// validEntryName reports whether name is a clean, relative vault path which
+// does not refer outside of the directory it is restored to.
+func validEntryName(name string) bool {
+	return name != "" && name == cleanEntryName(name)
+}
+
+// entriesUnder returns the latest version of the files which are stored under
+// the directory prefix in the vault.
+// The prefix "" refers to the root of the vault, so all files are returned.
+func (v *AESVault) entriesUnder(prefix string) []*AESVaultEntry {
+	var entries []*AESVaultEntry
+	for _, entry := range v.latestEntries() {
+		if prefix == "" || strings.HasPrefix(entry.Filename, prefix+"/") {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// isDir reports whether dir is a directory in the vault, that is, if there are
+// files under it.
+// The root of the vault ("") is always a directory.
+func (v *AESVault) isDir(dir string) bool {
+	return dir == "" || len(v.entriesUnder(dir)) != 0
+}
+
+// dirChildren returns the names of the files and directories (which end with
+// "/") directly under the directory dir in the vault, in sorted order.
+func (v *AESVault) dirChildren(dir string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, entry := range v.entriesUnder(dir) {
+		name := entry.Filename
+		if dir != "" {
+			name = strings.TrimPrefix(name, dir+"/")
+		}
+		if idx := strings.Index(name, "/"); idx != -1 {
+			name = name[:idx+1]
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addDir recursively encrypts the files in the directory dir and adds them to
+// the vault, under the directory root in the vault (see treeAdditions).
+// A failure does not stop the other files from being added; the report lists
+// the files which were added and those which failed.
+func (v *AESVault) addDir(dir, root string) *BulkReport {
+	report := &BulkReport{Op: "added"}
+	if v.readOnly {
+		report.Failed = []BulkError{{Name: dir, Err: errReadOnly}}
+		return report
+	}
+	report.Succeeded, report.Failed = v.addAll(v.treeAdditions(dir, root), DefaultWorkers)
+	return report
+}
+
+// RetrieveDir decrypts all the files stored under the directory prefix in the
+// vault, recreating the tree structure under output.
+// If output is empty, the tree is restored to a directory called prefix.
+// The prefix "." refers to the root of the vault, in which case all files are
+// retrieved.
+func (v *AESVault) RetrieveDir(prefix, output string) error {
+	retrievals, err := v.dirRetrievals(prefix, output)
+	if err != nil {
+		return err
+	}
+	return v.RetrieveAll(retrievals, DefaultWorkers).Err()
+}
+
+// dirRetrievals returns the retrievals of the files stored under the directory
+// prefix in the vault, as performed by RetrieveDir.
+func (v *AESVault) dirRetrievals(prefix, output string) ([]Retrieval, error) {
+	prefix = cleanEntryName(prefix)
+	entries := v.entriesUnder(prefix)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entries under '%s' in vault", prefix)
+	}
+	if output == "" {
+		output = filepath.FromSlash(prefix)
+	}
+	return treeRetrievals(entries, prefix, output)
+}
+
+// RemoveDir removes all the files (all of their versions) stored under the
+// directory prefix in the vault.
+func (v *AESVault) RemoveDir(prefix string) error {
+	prefix = cleanEntryName(prefix)
+	if prefix == "" {
+		return fmt.Errorf("cannot remove the root of the vault")
+	}
+	if !v.isDir(prefix) {
+		return fmt.Errorf("no entries under '%s' in vault", prefix)
+	}
+	return v.removeEntries(func(entry *AESVaultEntry) bool {
+		return strings.HasPrefix(entry.Filename, prefix+"/")
+	})
+}
+
+// RetrieveFiles decrypts the given entries, saving each one under output at
+// the path given by its name (creating directories as needed).
+func (v *AESVault) RetrieveFiles(entries []*AESVaultEntry, output string) error {
+	retrievals, err := treeRetrievals(entries, "", output)
+	if err != nil {
+		return err
+	}
+	return v.RetrieveAll(retrievals, DefaultWorkers).Err()
+}
+
+// treeRetrievals returns the retrievals of the entries, which are stored under
+// the directory prefix in the vault, to their paths relative to prefix under
+// output.
+func treeRetrievals(entries []*AESVaultEntry, prefix, output string) ([]Retrieval, error) {
+	var retrievals []Retrieval
+	for _, entry := range entries {
+		if !validEntryName(entry.Filename) {
+			return nil, fmt.Errorf("refusing to restore entry with invalid name '%s'", entry.Filename)
+		}
+		rel := entry.Filename
+		if prefix != "" {
+			rel = strings.TrimPrefix(rel, prefix+"/")
+		}
+		retrievals = append(retrievals, Retrieval{Entry: entry, Output: filepath.Join(output, filepath.FromSlash(rel))})
+	}
+	return retrievals, nil
+}
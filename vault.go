@@ -1,10 +1,16 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"time"
 )
 
 type encType uint8
@@ -35,38 +41,98 @@ type AESVault struct {
 	Name       string
 	Encryption encType
 	key        []byte
+	params     VaultParams
+	meta       MetadataOptions
+	annotation Annotation
 	Files      []*AESVaultEntry
+	// MaxVersions is the number of versions of each file retained in the vault;
+	// 0 means that all versions are retained.
+	MaxVersions int
+	Snapshots   []*Snapshot
+	// FullText is set if the vault maintains a full-text index of its files
+	FullText  bool
+	TextIndex map[string][]string
+	// ParityOverhead is the size of the parity data computed for each
+	// ciphertext, as a percentage of its size; 0 means that no parity data is
+	// computed.
+	ParityOverhead int
+	// Trash holds the removed entries for TrashDays days; 0 disables the trash
+	Trash     []*TrashItem
+	TrashDays int
+	// Generation counts the saves of the vault file; it is also recorded in
+	// the params file, so that an older copy of the vault file (restored from
+	// a backup, for example) can be detected
+	Generation uint64
+	// readOnly is set for views of snapshots, which cannot be modified
+	readOnly bool
 }
 
+var errReadOnly = fmt.Errorf("vault snapshots are read-only")
+
 // NewAESVault creates a new AESValut as a file in the file system.
-// `enc` specifies the AES variant to use and
-// `key` is the encryption key to be used.
+// `params` specifies the AES variant to use and how the encryption key is
+// derived from `key`.
 //
 // Note that the key is not directly used to encrypt the files.
-// Instead, the key is hashed and the resulting checksum bytes are used as key
-// bytes for the chosen AES variant.
-func NewAESVault(enc encType, name string, key []byte) (*AESVault, error) {
-	err := os.Mkdir(name, os.ModeDir|0777)
+// Instead, the key is hashed (or derived using the selected key derivation
+// function) and the resulting bytes are used as key bytes for the chosen AES
+// variant.
+func NewAESVault(name string, params VaultParams, key []byte) (*AESVault, error) {
+	enc, err := params.encryption()
+	if err != nil {
+		return nil, err
+	}
+	derived, err := params.deriveKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Mkdir(name, os.ModeDir|0777)
 	if os.IsExist(err) {
 		return nil, fmt.Errorf("directory with vault name '%s' already exists", name)
 	} else if err != nil {
 		return nil, fmt.Errorf("error creating vault directory '%s': %s", name, err.Error())
 	}
+	if err := writeParams(name, params); err != nil {
+		os.Remove(name)
+		return nil, err
+	}
 	v := &AESVault{
 		dirName:    name,
 		Encryption: enc,
-		key:        processKey(enc, key),
+		key:        derived,
+		params:     params,
+		meta:       DefaultMetadataOptions,
+		TrashDays:  DefaultTrashDays,
 	}
 	return v, nil
 }
 
-func OpenAESVault(enc encType, name string, key []byte) (*AESVault, error) {
+// OpenAESVault opens the vault in the directory name, deriving its encryption
+// key from `key` according to the vault's parameters.
+func OpenAESVault(name string, key []byte) (*AESVault, error) {
+	params, err := readParams(name)
+	if err != nil {
+		return nil, err
+	}
+	derived, err := params.deriveKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return openWithKey(name, params, derived)
+}
+
+// openWithKey opens the vault in the directory name with its (derived)
+// encryption key.
+func openWithKey(name string, params VaultParams, key []byte) (*AESVault, error) {
 	v := new(AESVault)
 	v.dirName = name
-	v.key = processKey(enc, key)
+	v.params = params
+	v.key = key
+	v.meta = DefaultMetadataOptions
 	if err := v.decodeFromFile(name); err != nil {
 		return nil, fmt.Errorf("vault decode error: %s", err.Error())
 	}
+	v.migrate()
 	return v, nil
 }
 
@@ -80,91 +146,326 @@ func processKey(enc encType, key []byte) []byte {
 	return keyHash[:32-int(enc)*8]
 }
 
-func (v *AESVault) encryptedFileName(srcFile string) string {
-	hash := sha256.Sum256([]byte(srcFile))
-	return v.dirName + "/" + base64.URLEncoding.EncodeToString(hash[:])
+// createCiphertext creates a new, randomly named, ciphertext file in the vault
+// directory.
+// Every version of every file is stored in its own ciphertext file, so that
+// adding a file never overwrites an existing ciphertext.
+func (v *AESVault) createCiphertext() (*os.File, error) {
+	for {
+		id := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(rand.Reader, id); err != nil {
+			return nil, fmt.Errorf("failed to generate ciphertext name: %s", err.Error())
+		}
+		name := v.dirName + "/" + base64.URLEncoding.EncodeToString(id)
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
 }
 
+// ciphertextPath returns the path of the ciphertext of entry.
+// Entries store the name of their ciphertext within the vault directory, so
+// that the vault can be used from any directory (vaults created before this
+// stored paths relative to the directory the vault was used from; only their
+// base name is used).
+func (v *AESVault) ciphertextPath(entry *AESVaultEntry) string {
+	return filepath.Join(v.dirName, filepath.Base(filepath.FromSlash(entry.EncryptedName)))
+}
+
+// lookupFile returns the latest version of the file entry with the given name,
+// along with its index in the vault's entries.
 func (v *AESVault) lookupFile(name string) (int, *AESVaultEntry) {
+	idx := -1
 	for i, entry := range v.Files {
-		if entry.Filename == name {
-			return i, entry
+		if entry.Filename == name && (idx == -1 || entry.Version > v.Files[idx].Version) {
+			idx = i
 		}
 	}
-	return -1, nil
+	if idx == -1 {
+		return -1, nil
+	}
+	return idx, v.Files[idx]
 }
 
 func (v *AESVault) Close() error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	// errors deleting expired items are ignored; the items are kept and deleted
+	// the next time the vault is saved
+	v.expireTrash(time.Now())
 	// write the vault to disk
 	return v.encodeToFile()
 }
 
-func (v *AESVault) ListFiles() []VaultEntry {
-	var files []VaultEntry
-	for _, e := range v.Files {
-		files = append(files, e)
+// AddFile encrypts the file at the given path and adds it to the vault.
+// The entry is named after the base name of the file.
+// If the vault already has a file with that name, a new version of it is added.
+func (v *AESVault) AddFile(name string) error {
+	return v.addFile(name, filepath.Base(name))
+}
+
+// addFile encrypts the file at srcPath and adds it to the vault as an entry
+// called entryName.
+func (v *AESVault) addFile(srcPath, entryName string) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	entry, err := v.encryptFile(srcPath, entryName)
+	if err != nil {
+		return err
 	}
-	return files
+	v.addVersion(entry)
+	v.indexFile(entry, srcPath)
+	return nil
 }
 
-func (v *AESVault) AddFile(name string) error {
+// encryptFile encrypts the file at srcPath into a new ciphertext, and returns
+// its entry (called entryName) without adding it to the vault.
+// It does not modify the vault, so it may be called concurrently.
+func (v *AESVault) encryptFile(srcPath, entryName string) (*AESVaultEntry, error) {
 	// open the src file
-	src, err := os.Open(name)
+	src, err := os.Open(srcPath)
 	if err != nil {
-		return fmt.Errorf("error opening src file '%s': %s", name, err.Error())
+		return nil, fmt.Errorf("error opening src file '%s': %s", srcPath, err.Error())
 	}
 	defer src.Close()
+	if info, err := src.Stat(); err != nil {
+		return nil, fmt.Errorf("failed to stat src file '%s': %s", srcPath, err.Error())
+	} else if info.IsDir() {
+		return nil, fmt.Errorf("'%s' is a directory", srcPath)
+	}
 	// open the dst file
-	dst, err := os.OpenFile(v.encryptedFileName(name), os.O_CREATE|os.O_WRONLY, 0666)
+	dst, err := v.createCiphertext()
 	if err != nil {
-		return fmt.Errorf("error creating dst file: %s", err.Error())
+		return nil, fmt.Errorf("error creating dst file: %s", err.Error())
 	}
 	defer dst.Close()
-	// encrypt and add src file to the vault
-	if entry, err := v.encrypt(src, dst); err != nil {
-		return fmt.Errorf("encryption error: %s", err.Error())
-	} else {
-		v.Files = append(v.Files, entry)
+	// encrypt the src file
+	entry, err := v.encrypt(entryName, src, dst)
+	if err != nil {
+		os.Remove(dst.Name())
+		return nil, fmt.Errorf("encryption error: %s", err.Error())
 	}
-	return nil
+	if err := v.recordMetadata(entry, srcPath); err != nil {
+		os.Remove(dst.Name())
+		return nil, fmt.Errorf("error reading metadata of '%s': %s", srcPath, err.Error())
+	}
+	if v.ParityOverhead > 0 {
+		if entry.Parity, err = v.writeParity(entry, parityShards(v.ParityOverhead)); err != nil {
+			os.Remove(dst.Name())
+			return nil, fmt.Errorf("error computing parity data: %s", err.Error())
+		}
+	}
+	return entry, nil
 }
 
+// RetrieveFile decrypts the file stored under the given name and saves it to
+// output.
+// The latest version of the file is retrieved, unless a version is specified
+// using the "name@version" syntax.
+// If output is empty, the file is saved in the current directory under the base
+// name of its entry.
 func (v *AESVault) RetrieveFile(name, output string) error {
 	// retrieve the entry (if any) corresponding to the specified file
-	_, entry := v.lookupFile(name)
+	entry := v.resolveFile(name)
 	if entry == nil {
 		return fmt.Errorf("no entry for '%s' in vault", name)
 	}
-	// open the encrypted file
-	src, err := os.Open(entry.EncryptedName)
-	if err != nil {
-		return fmt.Errorf("error opening encryted file: %s", err.Error())
-	}
-	// open a file to save decrypted output
 	if output == "" {
-		output = entry.Filename
+		output = path.Base(entry.Filename)
 	}
-	dst, err := os.Create(output)
+	return v.retrieveEntry(entry, output)
+}
+
+// retrieveEntry decrypts the ciphertext corresponding to entry and saves the
+// plaintext to the file called output.
+// The plaintext is written to a temporary file (only accessible by the user)
+// next to output, which is given the recorded metadata of the entry (its mode,
+// or else 0600) and replaces output once the ciphertext is authenticated.
+func (v *AESVault) retrieveEntry(entry *AESVaultEntry, output string) error {
+	// open a file to save decrypted output
+	dst, err := ioutil.TempFile(filepath.Dir(output), "."+filepath.Base(output)+".tmp-")
 	if err != nil {
 		return fmt.Errorf("error creating output file: %s", err.Error())
 	}
-
-	if err := v.decrypt(entry, src, dst); err != nil {
+	if err := v.readEntry(entry, dst); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
 		return err
 	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return fmt.Errorf("error writing output file: %s", err.Error())
+	}
+	// the metadata is restored once the contents have been written, otherwise
+	// writes would clobber the modification time
+	if err := v.restoreMetadata(entry, dst.Name()); err != nil {
+		os.Remove(dst.Name())
+		return fmt.Errorf("error restoring metadata of '%s': %s", output, err.Error())
+	}
+	if err := os.Rename(dst.Name(), output); err != nil {
+		os.Remove(dst.Name())
+		return fmt.Errorf("error creating output file: %s", err.Error())
+	}
 	return nil
 }
 
+// readEntry decrypts the ciphertext corresponding to entry, writing the
+// plaintext to w.
+func (v *AESVault) readEntry(entry *AESVaultEntry, w io.Writer) error {
+	src, err := os.Open(v.ciphertextPath(entry))
+	if err != nil {
+		return fmt.Errorf("error opening encryted file: %s", err.Error())
+	}
+	defer src.Close()
+	return v.decrypt(entry, src, w)
+}
+
+// RemoveFile removes all versions of the file with the given name from the
+// vault, moving them to the trash (or deleting their ciphertexts, if the trash
+// is disabled).
+// A single version can be removed using the "name@version" syntax.
 func (v *AESVault) RemoveFile(name string) error {
-	idx, entry := v.lookupFile(name)
-	if entry == nil {
-		return fmt.Errorf("no entry for '%s' in vault", name)
+	entries, err := v.FileEntries(name)
+	if err != nil {
+		return err
 	}
-	if err := os.Remove(entry.EncryptedName); err != nil {
-		return fmt.Errorf("failed to delete encrypted file: %s", err.Error())
+	return v.RemoveEntries(entries)
+}
+
+// FileEntries returns the entries of all versions of the file with the given
+// name, or of a single version using the "name@version" syntax.
+func (v *AESVault) FileEntries(name string) ([]*AESVaultEntry, error) {
+	if _, entry := v.lookupFile(name); entry != nil {
+		var entries []*AESVaultEntry
+		for _, e := range v.Files {
+			if e.Filename == name {
+				entries = append(entries, e)
+			}
+		}
+		return entries, nil
+	}
+	if entry := v.resolveFile(name); entry != nil {
+		return []*AESVaultEntry{entry}, nil
+	}
+	return nil, fmt.Errorf("no entry for '%s' in vault", name)
+}
+
+// RemoveEntries removes the given entries from the vault (see removeEntries).
+func (v *AESVault) RemoveEntries(entries []*AESVaultEntry) error {
+	remove := make(map[*AESVaultEntry]bool)
+	for _, entry := range entries {
+		remove[entry] = true
+	}
+	return v.removeEntries(func(entry *AESVaultEntry) bool { return remove[entry] })
+}
+
+// RemovalEffects describes, for each of the given entries, what removing it
+// (along with the others) from the vault would do.
+func (v *AESVault) RemovalEffects(entries []*AESVaultEntry) []string {
+	effects := make([]string, len(entries))
+	removed := make(map[*AESVaultEntry]bool)
+	for _, entry := range entries {
+		removed[entry] = true
+	}
+	refs := v.ciphertextRefsExcept(removed)
+	for i, entry := range entries {
+		switch {
+		case v.TrashDays > 0:
+			effects[i] = fmt.Sprintf("moved to the trash for %d days", v.TrashDays)
+		case refs[entry.EncryptedName]:
+			effects[i] = "deleted (its ciphertext is still referenced)"
+		default:
+			effects[i] = "deleted, with its ciphertext"
+		}
+	}
+	return effects
+}
+
+// removeEntries removes the entries for which remove returns true from the
+// vault, moving them to the trash if it is enabled, or else deleting their
+// ciphertexts unless they are still referenced (by a snapshot, for example).
+// The order of the remaining entries is preserved.
+func (v *AESVault) removeEntries(remove func(entry *AESVaultEntry) bool) error {
+	if v.readOnly {
+		return errReadOnly
+	}
+	var kept, removed []*AESVaultEntry
+	for _, entry := range v.Files {
+		if remove(entry) {
+			removed = append(removed, entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	v.Files = kept
+	if v.TrashDays > 0 {
+		v.trashEntries(removed)
+		return nil
+	}
+	return v.deleteUnreferenced(removed)
+}
+
+// ciphertextRefs returns the set of ciphertext (and parity) files referenced by
+// the vault's entries, snapshots and trash.
+func (v *AESVault) ciphertextRefs() map[string]bool {
+	return v.ciphertextRefsExcept(nil)
+}
+
+// ciphertextRefsExcept returns the set of ciphertext (and parity) files which
+// would still be referenced if the excluded entries were removed from the
+// vault (without moving them to the trash).
+func (v *AESVault) ciphertextRefsExcept(excluded map[*AESVaultEntry]bool) map[string]bool {
+	refs := make(map[string]bool)
+	add := func(entry *AESVaultEntry) {
+		refs[entry.EncryptedName] = true
+		if entry.Parity != nil {
+			refs[entry.Parity.File] = true
+		}
+	}
+	for _, entry := range v.Files {
+		if !excluded[entry] {
+			add(entry)
+		}
+	}
+	for _, item := range v.Trash {
+		if item.Entry != nil {
+			add(item.Entry)
+		} else {
+			refs[item.Ciphertext] = true
+		}
+	}
+	for _, snapshot := range v.Snapshots {
+		for _, entry := range snapshot.Files {
+			add(entry)
+		}
+	}
+	return refs
+}
+
+// deleteUnreferenced deletes the ciphertexts of the given entries which are not
+// referenced by the vault's entries or snapshots.
+// If a ciphertext cannot be deleted, it is left for the gc command to remove.
+func (v *AESVault) deleteUnreferenced(entries []*AESVaultEntry) error {
+	refs := v.ciphertextRefs()
+	deleted := make(map[string]bool)
+	defer v.unindex(deleted)
+	for _, entry := range entries {
+		if refs[entry.EncryptedName] {
+			continue
+		}
+		if err := os.Remove(v.ciphertextPath(entry)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete encrypted file: %s", err.Error())
+		}
+		if entry.Parity != nil && !refs[entry.Parity.File] {
+			if err := os.Remove(v.parityPath(entry)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to delete parity file: %s", err.Error())
+			}
+		}
+		deleted[entry.EncryptedName] = true
 	}
-	// remove entry from vault
-	v.Files[idx] = v.Files[len(v.Files)-1]
-	v.Files = v.Files[:len(v.Files)-1]
 	return nil
 }